@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/FFengIll/pstopo/pkg"
+)
+
+var agentToken = ""
+var agentListen = ""
+var agentInsecure = false
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "serve the snapshot of current host for collect",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token, err := requireToken(agentToken, agentInsecure)
+		if err != nil {
+			return err
+		}
+		agent := pkg.NewAgent(connectionKind, token)
+		logrus.WithField("listen", agentListen).Infoln("serve agent")
+		if err := http.ListenAndServe(agentListen, agent); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+// tokenOrEnv returns the token from cli, or from env `PSTOPO_TOKEN`
+func tokenOrEnv(token string) string {
+	if token != "" {
+		return token
+	}
+	return os.Getenv("PSTOPO_TOKEN")
+}
+
+// requireToken returns the token to serve with, it is an error to serve without token unless insecure
+func requireToken(token string, insecure bool) (string, error) {
+	token = tokenOrEnv(token)
+	if token == "" {
+		if !insecure {
+			return "", errors.New("no token given, use --token or env `PSTOPO_TOKEN`, or --insecure to serve anyone")
+		}
+		logrus.Warningln("no token given, anyone can fetch the snapshot")
+	}
+	return token, nil
+}
+
+func init() {
+	flags := agentCmd.Flags()
+	flags.StringVar(&agentListen, "listen", ":7071", "http listen address")
+	flags.StringVar(&agentToken, "token", "", "shared token, or env `PSTOPO_TOKEN`")
+	flags.BoolVar(&agentInsecure, "insecure", false, "serve without token, anyone reaching the address can fetch the snapshot")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/FFengIll/pstopo/pkg"
+)
+
+var baselinePath = ""
+
+var baselineCmd = &cobra.Command{
+	Use:   "baseline",
+	Short: "save a normalised topo, and check the drift against it",
+}
+
+var baselineSaveCmd = &cobra.Command{
+	Use:   "save [snapshot]",
+	Short: "save the baseline of a fresh or given snapshot",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		snapshot, err := loadOrTakeSnapshot(args)
+		if err != nil {
+			return err
+		}
+		baseline := pkg.NewBaseline(snapshot)
+		if err := baseline.DumpFile(baselineFile()); err != nil {
+			return err
+		}
+		logrus.WithFields(logrus.Fields{
+			"services":  len(baseline.Services),
+			"listeners": len(baseline.Listeners),
+			"edges":     len(baseline.Edges),
+		}).Infof("save baseline %s", baselineFile())
+		return nil
+	},
+}
+
+var baselineCheckCmd = &cobra.Command{
+	Use:   "check [snapshot]",
+	Short: "report the drift of a fresh or given snapshot, exit non-zero on drift",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		baseline, err := pkg.LoadBaseline(baselineFile())
+		if err != nil {
+			return fmt.Errorf("load baseline: %w", err)
+		}
+		snapshot, err := loadOrTakeSnapshot(args)
+		if err != nil {
+			return err
+		}
+
+		drifts := baseline.Drift(pkg.NewBaseline(snapshot))
+		switch reportFormat {
+		case "json":
+			if drifts == nil {
+				drifts = []*pkg.Drift{}
+			}
+			data, _ := json.MarshalIndent(drifts, "", "  ")
+			fmt.Println(string(data))
+		default:
+			for _, d := range drifts {
+				fmt.Printf("%s: %s\n", d.Kind, d.Item)
+			}
+			fmt.Printf("%d drifts\n", len(drifts))
+		}
+
+		if len(drifts) > 0 {
+			return &violationError{message: fmt.Sprintf("%d drifts against %s", len(drifts), baselineFile())}
+		}
+		return nil
+	},
+}
+
+// baselineFile returns the given baseline path, or the default one in output dir
+func baselineFile() string {
+	if baselinePath != "" {
+		return baselinePath
+	}
+	return path.Join(outputDir, "baseline.json")
+}
+
+func init() {
+	baselineCmd.AddCommand(baselineSaveCmd)
+	baselineCmd.AddCommand(baselineCheckCmd)
+
+	flags := baselineCmd.PersistentFlags()
+	flags.StringVar(&baselinePath, "baseline", "", "baseline file path, default may use `baseline.json` in output dir")
+
+	flags = baselineCheckCmd.Flags()
+	flags.StringVar(&reportFormat, "format", "text", "report format, `text` or `json`")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/FFengIll/pstopo/pkg"
+)
+
+var policyPath = ""
+var reportFormat = ""
+
+var checkCmd = &cobra.Command{
+	Use:   "check --policy policy.yaml [snapshot]",
+	Short: "check the topo against a policy, exit non-zero on violation",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		policy, err := pkg.LoadPolicy(policyPath)
+		if err != nil {
+			return fmt.Errorf("load policy: %w", err)
+		}
+
+		snapshot, err := loadOrTakeSnapshot(args)
+		if err != nil {
+			return err
+		}
+
+		violations, err := policy.Check(snapshot)
+		if err != nil {
+			return err
+		}
+
+		switch reportFormat {
+		case "json":
+			if violations == nil {
+				violations = []*pkg.Violation{}
+			}
+			data, _ := json.MarshalIndent(violations, "", "  ")
+			fmt.Println(string(data))
+		default:
+			for _, v := range violations {
+				if v.Item != "" {
+					fmt.Printf("%s [%s] %s: %s\n", v.Rule, v.Kind, v.Message, v.Item)
+				} else {
+					fmt.Printf("%s [%s] %s\n", v.Rule, v.Kind, v.Message)
+				}
+			}
+			fmt.Printf("%d rules, %d violations\n", len(policy.Rules), len(violations))
+		}
+
+		if len(violations) > 0 {
+			return &violationError{message: fmt.Sprintf("%d violations of %s", len(violations), policyPath)}
+		}
+		return nil
+	},
+}
+
+func init() {
+	flags := checkCmd.Flags()
+	flags.StringVar(&policyPath, "policy", "policy.yaml", "policy file path")
+	flags.StringVar(&reportFormat, "format", "text", "report format, `text` or `json`")
+}
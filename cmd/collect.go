@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/FFengIll/pstopo/pkg"
+)
+
+var collectFrom []string
+var collectTimeout = time.Duration(0)
+var collectCompress = ""
+
+var collectCmd = &cobra.Command{
+	Use:   "collect",
+	Short: "fetch snapshots from agents into the output dir",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(collectFrom) == 0 {
+			return errors.New("no given agent, use --from")
+		}
+		if collectCompress != pkg.CompressNone && collectCompress != pkg.CompressGzip && collectCompress != pkg.CompressZstd {
+			return fmt.Errorf("unknown compression %q, use `gzip` or `zstd`", collectCompress)
+		}
+		if err := fs.MkdirAll(outputDir, 0777); err != nil {
+			return err
+		}
+
+		collector := pkg.NewCollector(tokenOrEnv(agentToken), collectTimeout)
+		collector.Compress = collectCompress
+		results := collector.Collect(context.Background(), collectFrom, outputDir)
+
+		failed := 0
+		for _, result := range results {
+			if result.Err != nil {
+				failed++
+				logrus.WithError(result.Err).WithField("from", result.From).Errorln("collect error")
+				continue
+			}
+			logrus.WithField("from", result.From).Infof("snapshot to: %s", result.Path)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d agents failed", failed, len(results))
+		}
+		return nil
+	},
+}
+
+func init() {
+	flags := collectCmd.Flags()
+	flags.StringArrayVar(&collectFrom, "from", nil, "agent address, e.g. `host1:7071`, can be repeated")
+	flags.StringVar(&agentToken, "token", "", "shared token, or env `PSTOPO_TOKEN`")
+	flags.DurationVar(&collectTimeout, "timeout", 10*time.Second, "timeout of each agent")
+	flags.StringVar(&collectCompress, "compress", "", "compress the snapshots by `gzip` or `zstd`")
+}
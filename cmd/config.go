@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/FFengIll/pstopo/pkg"
+)
+
+// configNames are looked up in the output dir by order, if no config is given
+var configNames = []string{"config.yaml", "config.yml", "config.toml", "config.json"}
+
+// defaultConfigPath is the first existing config in the dir, or `config.json` in it
+func defaultConfigPath(dir string) string {
+	for _, name := range configNames {
+		if p := path.Join(dir, name); existFile(p) {
+			return p
+		}
+	}
+	return path.Join(dir, "config.json")
+}
+
+var configForce = false
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "validate config, or write a default one",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [path]",
+	Short: "validate config against the schema, default may use the config in output dir",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p := configPath
+		if len(args) > 0 {
+			p = args[0]
+		}
+		if p == "" {
+			p = defaultConfigPath(outputDir)
+		}
+
+		if _, err := pkg.LoadConfig(p); err != nil {
+			var invalid *pkg.ConfigErrors
+			if errors.As(err, &invalid) {
+				return &violationError{message: err.Error()}
+			}
+			return err
+		}
+		fmt.Printf("%s: ok\n", p)
+		return nil
+	},
+}
+
+var configInitCmd = &cobra.Command{
+	Use:   "init [path]",
+	Short: "write a commented default config, in yaml, toml or json by the extension",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p := path.Join(outputDir, "config.yaml")
+		if len(args) > 0 {
+			p = args[0]
+		}
+		if existFile(p) && !configForce {
+			return fmt.Errorf("%s exists, use --force to overwrite", p)
+		}
+
+		data, err := pkg.DefaultConfigText(pkg.ConfigFormat(p))
+		if err != nil {
+			return err
+		}
+		if dir := path.Dir(p); dir != "" {
+			if err := fs.MkdirAll(dir, 0777); err != nil {
+				return err
+			}
+		}
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			return err
+		}
+		logrus.Infof("config to: %s", p)
+		return nil
+	},
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "print the json schema of config",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := json.MarshalIndent(pkg.ConfigSchema(), "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Println(string(data))
+		return err
+	},
+}
+
+func init() {
+	configInitCmd.Flags().BoolVarP(&configForce, "force", "f", false, "overwrite the existing config")
+
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configInitCmd)
+	configCmd.AddCommand(configSchemaCmd)
+}
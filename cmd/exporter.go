@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/FFengIll/pstopo/pkg"
+)
+
+var exporterListen = ""
+
+var exporterCmd = &cobra.Command{
+	Use:   "metrics-exporter",
+	Short: "serve the topo statistics as prometheus metrics on /metrics",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		exporter := pkg.NewExporter(connectionKind)
+		logrus.WithField("listen", exporterListen).Infoln("serve metrics")
+		if err := http.ListenAndServe(exporterListen, exporter); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	flags := exporterCmd.Flags()
+	flags.StringVar(&exporterListen, "listen", ":9477", "http listen address")
+}
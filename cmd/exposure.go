@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/FFengIll/pstopo/pkg"
+)
+
+var exposureCmd = &cobra.Command{
+	Use:   "exposure [snapshot]",
+	Short: "list the listening sockets with the bind address and the owner",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		snapshot, err := loadOrTakeSnapshot(args)
+		if err != nil {
+			return err
+		}
+		list := snapshot.Exposures()
+
+		switch reportFormat {
+		case "json":
+			if list == nil {
+				list = []*pkg.Exposure{}
+			}
+			data, _ := json.MarshalIndent(list, "", "  ")
+			fmt.Println(string(data))
+		case "dot":
+			if err := fs.MkdirAll(outputDir, 0777); err != nil {
+				return err
+			}
+			outputPath := path.Join(outputDir, "exposure.dot")
+			logrus.WithField("output", outputPath).Infoln("output dot and png")
+			render, err := pkg.NewDotRender()
+			if err != nil {
+				return err
+			}
+			defer render.Close()
+			if err := render.WriteExposure(list, outputPath); err != nil {
+				return err
+			}
+		default:
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "\tADDR\tPORT\tBIND\tPID\tPROCESS\tUSER\tEXEC")
+			for _, e := range list {
+				mark := ""
+				if e.Highlight {
+					mark = "!"
+				}
+				addr := e.Addr
+				if e.NetNS != 0 {
+					addr = fmt.Sprintf("%s (netns %d)", e.Addr, e.NetNS)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%s\t%s\t%s\n",
+					mark, addr, e.Port, e.Bind, e.Pid, e.Process, e.User, e.Exec)
+			}
+			w.Flush()
+		}
+		return nil
+	},
+}
+
+func init() {
+	flags := exposureCmd.Flags()
+	flags.StringVar(&reportFormat, "format", "text", "report format, `text`, `json` or `dot` (into output dir)")
+}
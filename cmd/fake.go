@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/FFengIll/pstopo/pkg"
+)
+
+var fakeOutput = ""
+var fakeSeed = int64(0)
+var fakePrintSpec = false
+
+var fakeCmd = &cobra.Command{
+	Use:   "fake [spec.yaml] -o out.json",
+	Short: "generate a synthetic snapshot from a spec, or the demo one, for tests and demos",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spec := pkg.DefaultFakeSpec()
+		if len(args) > 0 {
+			var err error
+			if spec, err = pkg.LoadFakeSpec(args[0]); err != nil {
+				return err
+			}
+		}
+		if cmd.Flags().Changed("seed") {
+			spec.Seed = fakeSeed
+		}
+
+		if fakePrintSpec {
+			if err := spec.Validate(); err != nil {
+				return err
+			}
+			return yaml.NewEncoder(os.Stdout).Encode(spec)
+		}
+
+		snapshot, err := pkg.GenerateSnapshot(spec)
+		if err != nil {
+			return err
+		}
+		if fakeOutput == "" || fakeOutput == "-" {
+			return snapshot.Encode(os.Stdout)
+		}
+		return snapshot.DumpFile(fakeOutput)
+	},
+}
+
+func init() {
+	flags := fakeCmd.Flags()
+	flags.StringVarP(&fakeOutput, "output", "o", "", "snapshot file path, stdout if none")
+	flags.Int64Var(&fakeSeed, "seed", 0, "random seed of ports and pids, the one of spec if not given")
+	flags.BoolVar(&fakePrintSpec, "print-spec", false, "print the spec in yaml, e.g. to start with the demo one")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/FFengIll/pstopo/pkg"
+)
+
+var historyPath = ""
+var recordEvery = time.Duration(0)
+var recordCount = 0
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "record and list snapshots over time",
+}
+
+var historyRecordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "take snapshots at an interval into the history file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		recorder, err := pkg.OpenRecorder(historyFile())
+		if err != nil {
+			return err
+		}
+		defer recorder.Close()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		ticker := time.NewTicker(recordEvery)
+		defer ticker.Stop()
+		for i := 0; recordCount <= 0 || i < recordCount; i++ {
+			if i > 0 {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+				}
+			}
+
+			snapshot, err := takeSnapshot()
+			if err != nil {
+				logrus.WithError(err).Errorln("skip the frame")
+				continue
+			}
+			frame, err := recorder.Record(snapshot, time.Now())
+			if err != nil {
+				return err
+			}
+			logrus.WithFields(logrus.Fields{
+				"process": len(frame.Processes),
+				"exited":  len(frame.Exited),
+				"opened":  len(frame.Opened),
+				"closed":  len(frame.Closed),
+			}).Infof("record at %s", frame.Time.Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+var historyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the captures in the history file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		frames, err := pkg.ReadHistory(historyFile())
+		if err != nil {
+			return err
+		}
+		for _, f := range frames {
+			fmt.Printf("%s\t+%d -%d process\t+%d -%d connection\n",
+				f.Time.Format(time.RFC3339), len(f.Processes), len(f.Exited), len(f.Opened), len(f.Closed))
+		}
+		return nil
+	},
+}
+
+// historyFile returns the given history path, or the default one in output dir
+func historyFile() string {
+	if historyPath != "" {
+		return historyPath
+	}
+	return path.Join(outputDir, "history.jsonl")
+}
+
+// parseTime accepts RFC3339 or a local time like `2026-10-18T10:00`
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time %q, e.g. 2026-10-18T10:00", value)
+}
+
+func init() {
+	historyCmd.AddCommand(historyRecordCmd)
+	historyCmd.AddCommand(historyListCmd)
+
+	flags := historyCmd.PersistentFlags()
+	flags.StringVar(&historyPath, "history", "", "history file path, default may use `history.jsonl` in output dir")
+
+	flags = historyRecordCmd.Flags()
+	flags.DurationVar(&recordEvery, "every", 10*time.Second, "interval between captures")
+	flags.IntVar(&recordCount, "count", 0, "number of captures, 0 for until interrupted")
+}
@@ -1,13 +1,15 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
-	jsoniter "github.com/json-iterator/go"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
@@ -39,73 +41,76 @@ var rootCmd = &cobra.Command{
 			logrus.SetLevel(logrus.DebugLevel)
 		}
 	},
-	Run: func(cmd *cobra.Command, args []string) {
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		config := pkg.NewConfig()
 
 		err := fs.MkdirAll(outputDir, 0777)
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		if snapshotPath == "" {
-			snapshotPath = path.Join(outputDir, "snapshot.json")
+			snapshotPath = defaultSnapshotPath(outputDir)
 			logrus.WithField("snapshot", snapshotPath).Infoln("set default snapshot path")
 		}
 
-		var snapshot *pkg.Snapshot
-		if !existFile(snapshotPath) {
-			logrus.WithField("snapshot", snapshotPath).Infoln("no snapshot existed, take one")
-			// if no given snapshot, then generate a new one
-			snapshot, _ = pkg.TakeSnapshot(connectionKind)
-			snapshot.DumpFile(snapshotPath)
-		} else {
-			var json = jsoniter.ConfigCompatibleWithStandardLibrary
-			data, _ := os.ReadFile(snapshotPath)
-			err := json.Unmarshal(data, &snapshot)
-			if err != nil {
-				panic(err)
-			}
-		}
-
 		if configPath == "" {
-			configPath = path.Join(outputDir, "config.json")
+			configPath = defaultConfigPath(outputDir)
 			logrus.WithField("config", configPath).Infoln("set default config path")
 		}
 
-		if !existFile(configPath) {
+		configExisted := existFile(configPath)
+		if !configExisted {
 			if len(args) <= 0 {
 				config.All = true
 			} else {
 				config.All = false
 			}
 		} else {
-			var json = jsoniter.ConfigCompatibleWithStandardLibrary
-			data, _ := os.ReadFile(configPath)
-			err := json.Unmarshal(data, &config)
+			config, err = pkg.LoadConfig(configPath)
 			if err != nil {
-				panic(err)
+				return err
 			}
 		}
 
 		// add filter options from cli
 		for _, arg := range args {
-			// :xx as port
-			// yy as cmdline
-			if strings.HasPrefix(arg, ":") {
-				port, _ := strconv.Atoi(arg[1:])
-				config.Port = append(config.Port, uint32(port))
-				continue
-			}
+			addFilterArg(config, arg)
+		}
 
-			// ip := net.ParseIP(arg)
-			// if ip != nil {
-			//
-			// }
+		if err := applyOptions(config); err != nil {
+			return err
+		}
 
-			config.Cmd = append(config.Cmd, arg)
+		// the config of the user is not rewritten, only the first one is saved
+		if !configExisted {
+			if err := dumpConfigFile(config, configPath); err != nil {
+				return fmt.Errorf("save config: %w", err)
+			}
 		}
 
-		dumpConfigFile(config, configPath)
+		var snapshot *pkg.Snapshot
+		if !existFile(snapshotPath) {
+			logrus.WithField("snapshot", snapshotPath).Infoln("no snapshot existed, take one")
+			// if no given snapshot, then generate a new one
+			snapshot, err = takeSnapshotFor(config)
+			if err != nil {
+				return err
+			}
+			if err := snapshot.DumpFile(snapshotPath); err != nil {
+				return fmt.Errorf("save snapshot: %w", err)
+			}
+		} else {
+			snapshot, err = pkg.LoadSnapshot(snapshotPath)
+			if err != nil {
+				return err
+			}
+			if snapshot.Targeted {
+				logrus.WithField("snapshot", snapshotPath).Warningln("targeted snapshot, the topo of other filters may miss processes")
+			}
+		}
 
 		var topo *pkg.PSTopo
 		topo = pkg.NewTopo(snapshot)
@@ -113,18 +118,89 @@ var rootCmd = &cobra.Command{
 
 		outputPath := path.Join(outputDir, "output.dot")
 		logrus.WithField("output", outputPath).Infoln("output dot and png")
-		render, _ := pkg.NewDotRender()
-		render.Write(topo, outputPath)
+		return renderOutput(topo, outputPath)
 	},
 }
 
+// renderOutput writes the dot file of the topo, and the png of it aside
+func renderOutput(topo *pkg.PSTopo, outputPath string) error {
+	render, err := pkg.NewRender("dot")
+	if err != nil {
+		return err
+	}
+	if c, ok := render.(io.Closer); ok {
+		defer c.Close()
+	}
+	if err := pkg.RenderFile(render, topo, outputPath, &pkg.RenderOptions{Format: "dot"}); err != nil {
+		return err
+	}
+	return pkg.RenderFile(render, topo, outputPath+".png", &pkg.RenderOptions{Format: "png"})
+}
+
+// addFilterArg adds a filter option from cli
+func addFilterArg(config *pkg.Config, arg string) {
+	// :xx as port
+	// yy as cmdline
+	if strings.HasPrefix(arg, ":") {
+		port, _ := strconv.Atoi(arg[1:])
+		config.Port = append(config.Port, uint32(port))
+		return
+	}
+
+	// container:zz as container id
+	if strings.HasPrefix(arg, "container:") {
+		config.Container = append(config.Container, strings.TrimPrefix(arg, "container:"))
+		return
+	}
+
+	// unit:zz as systemd unit
+	if strings.HasPrefix(arg, "unit:") {
+		config.Unit = append(config.Unit, strings.TrimPrefix(arg, "unit:"))
+		return
+	}
+
+	// ip := net.ParseIP(arg)
+	// if ip != nil {
+	//
+	// }
+
+	config.Cmd = append(config.Cmd, arg)
+}
+
+// applyOptions overrides the config with grouping and cluster options from cli
+func applyOptions(config *pkg.Config) error {
+	if groupPrefix < 0 || groupPrefix > 32 {
+		return fmt.Errorf("--group-prefix %d is out of range 0..32", groupPrefix)
+	}
+	if groupPrefix6 < 0 || groupPrefix6 > 128 {
+		return fmt.Errorf("--group-prefix6 %d is out of range 0..128", groupPrefix6)
+	}
+	if clusterBy != "" {
+		config.Cluster = clusterBy
+	}
+	if groupIP {
+		config.Group = true
+	}
+	if groupPrefix > 0 {
+		config.GroupPrefix = groupPrefix
+	}
+	if groupPrefix6 > 0 {
+		config.GroupPrefix6 = groupPrefix6
+	}
+	return nil
+}
+
 func fixSnapshotPath(name string) string {
+	// keep the compression extension at the end, e.g. `.snapshot.json.zst`
+	ext := ""
+	if pkg.CompressionOf(name) != pkg.CompressNone {
+		ext = path.Ext(name)
+		name = strings.TrimSuffix(name, ext)
+	}
 	if !strings.HasSuffix(name, ".snapshot.json") {
-		res := name + ".snapshot.json"
-		return res
-	} else {
-		return name
+		name += ".snapshot.json"
 	}
+	return name + ext
 }
 
 func init() {
@@ -132,18 +208,64 @@ func init() {
 
 	rootCmd.AddCommand(snapshotCmd)
 	rootCmd.AddCommand(reloadCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(mergeCmd)
+	rootCmd.AddCommand(agentCmd)
+	rootCmd.AddCommand(collectCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(checkCmd)
+	rootCmd.AddCommand(baselineCmd)
+	rootCmd.AddCommand(exposureCmd)
+	rootCmd.AddCommand(exporterCmd)
+	rootCmd.AddCommand(otlpCmd)
+	rootCmd.AddCommand(redactCmd)
+	rootCmd.AddCommand(fakeCmd)
+	rootCmd.AddCommand(configCmd)
+
+	addSampleFlags(rootCmd)
 
 	flags := rootCmd.PersistentFlags()
-	flags.StringVarP(&snapshotPath, "snapshot", "s", "", "local cached snapshot file path, default may use `snapshot.json`")
-	flags.StringVarP(&configPath, "config", "c", "", "local config file path, default may use `config.json`")
+	flags.StringVarP(&snapshotPath, "snapshot", "s", "", "local cached snapshot file path, compressed if `.gz` or `.zst`, default may use `snapshot.json`")
+	flags.StringVarP(&configPath, "config", "c", "", "local config file path in json, yaml or toml, default may use `config.yaml`, `config.toml` or `config.json`")
 	flags.StringVarP(&outputDir, "output", "o", "output", "output dir path")
 	flags.StringVarP(&connectionKind, "kind", "k", "all", "connection kind")
 	flags.BoolVarP(&verbose, "verbose", "v", false, "verbose with debug info")
+	flags.StringVar(&hostsPath, "hosts", "", "resolve remote ip with a hosts file, e.g. `/etc/hosts`")
+	flags.StringVar(&namesPath, "names", "", "resolve remote ip with a json mapping of ip or cidr to name")
+	flags.BoolVar(&reverseDNS, "dns", false, "resolve remote ip with reverse dns")
+	flags.DurationVar(&dnsTimeout, "dns-timeout", time.Second, "timeout of each reverse dns query")
+	flags.BoolVar(&groupIP, "group", false, "group external ip by configured networks or by prefix block")
+	flags.StringVar(&clusterBy, "cluster", "", "cluster nodes by `container` (default), `unit` or `none`")
+	flags.IntVar(&groupPrefix, "group-prefix", 0, "prefix length of the fallback ipv4 block for grouping, default 24")
+	flags.IntVar(&groupPrefix6, "group-prefix6", 0, "prefix length of the fallback ipv6 block for grouping, default 64")
+	flags.DurationVar(&snapshotTimeout, "snapshot-timeout", 0, "timeout of taking snapshot, e.g. `30s`, no timeout if 0")
+	flags.IntVar(&snapshotWorkers, "workers", 0, "number of workers to inspect processes, default by cpu")
+	flags.BoolVar(&targeted, "targeted", false, "inspect only the processes of the filter, their ancestors, children and peers")
+	flags.BoolVar(&redact, "redact", false, "redact the taken snapshot, mask secrets and pseudonymise ips, hostnames and users")
+	flags.BoolVar(&stripCmdline, "strip-cmdline", false, "redact cmdline into the executable only")
+	flags.StringVar(&redactMap, "redact-map", "", "keep the pseudonyms in the mapping file, to be the same over snapshots")
+}
+
+// violationError is a failed check, which exits with 1, and other errors exit with 2
+type violationError struct {
+	message string
+}
+
+func (e *violationError) Error() string {
+	return e.message
+}
+
+func exitCode(err error) int {
+	var violation *violationError
+	if errors.As(err, &violation) {
+		return 1
+	}
+	return 2
 }
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/FFengIll/pstopo/pkg"
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge snapshot[=addr,...] ... [filter ...]",
+	Short: "merge snapshots of several hosts into one topo",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := pkg.NewConfig()
+
+		var hosts []*pkg.HostSnapshot
+		for _, arg := range args {
+			// path=addr1,addr2 to tag the host addresses
+			file, tag, _ := strings.Cut(arg, "=")
+			if !existFile(file) {
+				// a mistyped snapshot is not a filter
+				if tag != "" || isSnapshotPath(file) {
+					return fmt.Errorf("snapshot %s does not exist", file)
+				}
+				addFilterArg(config, arg)
+				continue
+			}
+
+			snapshot, err := pkg.LoadSnapshot(file)
+			if err != nil {
+				return err
+			}
+			var addrs []string
+			if tag != "" {
+				addrs = strings.Split(tag, ",")
+			}
+			host := pkg.NewHostSnapshot(snapshot, "", addrs)
+			if host.Name == "" {
+				host.Name = path.Base(file)
+			}
+			if len(host.Addrs) == 0 {
+				logrus.WithField("snapshot", file).Warningln("no host address, can not match across hosts")
+			}
+			hosts = append(hosts, host)
+		}
+
+		if len(hosts) == 0 {
+			return errors.New("no snapshot to merge")
+		}
+
+		config.All = len(config.Cmd) <= 0 && len(config.Port) <= 0 &&
+			len(config.Container) <= 0 && len(config.Unit) <= 0
+		if err := applyOptions(config); err != nil {
+			return err
+		}
+
+		if err := fs.MkdirAll(outputDir, 0777); err != nil {
+			return err
+		}
+		outputPath := path.Join(outputDir, "output.dot")
+		logrus.WithField("output", outputPath).Infoln("output dot and png")
+
+		topo := pkg.Merge(hosts, config)
+		render, err := pkg.NewDotRender()
+		if err != nil {
+			return err
+		}
+		defer render.Close()
+		if err := render.WriteMulti(topo, outputPath); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+// isSnapshotPath tells whether the arg looks like a snapshot file by the extension
+func isSnapshotPath(arg string) bool {
+	return strings.HasSuffix(arg, ".json") || pkg.CompressionOf(arg) != pkg.CompressNone
+}
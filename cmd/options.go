@@ -1,8 +1,27 @@
 package main
 
+import "time"
+
 var snapshotPath = ""
 var configPath = ""
 var outputDir = ""
 var connectionKind = ""
 var update = false
 var verbose = false
+var hostsPath = ""
+var namesPath = ""
+var reverseDNS = false
+var dnsTimeout = time.Second
+var groupIP = false
+var groupPrefix = 0
+var groupPrefix6 = 0
+var clusterBy = ""
+var reloadAt = ""
+var sampleWindow = time.Duration(0)
+var sampleEvery = time.Duration(0)
+var snapshotTimeout = time.Duration(0)
+var snapshotWorkers = 0
+var targeted = false
+var redact = false
+var stripCmdline = false
+var redactMap = ""
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/FFengIll/pstopo/pkg"
+)
+
+var otlpFile = ""
+var otlpEndpoint = ""
+var otlpHeaders []string
+var otlpTimeout = time.Duration(0)
+
+var otlpCmd = &cobra.Command{
+	Use:   "otlp [filter ...]",
+	Short: "export the service dependencies of the topo as OTLP/JSON traces",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := pkg.NewConfig()
+		for _, arg := range args {
+			addFilterArg(config, arg)
+		}
+		// match all processes by filter, which keeps the connections to ip
+		if len(config.Cmd) <= 0 && len(config.Port) <= 0 && len(config.Container) <= 0 && len(config.Unit) <= 0 {
+			config.Cmd = []string{"*"}
+		}
+		if err := applyOptions(config); err != nil {
+			return err
+		}
+
+		var snapshot *pkg.Snapshot
+		var err error
+		if snapshotPath != "" {
+			snapshot, err = pkg.LoadSnapshot(snapshotPath)
+		} else {
+			snapshot, err = takeSnapshotFor(config)
+		}
+		if err != nil {
+			return err
+		}
+
+		topo := pkg.NewTopo(snapshot).Analyse(config)
+		traces := topo.ServiceGraphTraces(time.Now())
+
+		if otlpEndpoint != "" {
+			headers := map[string]string{}
+			for _, h := range otlpHeaders {
+				k, v, ok := strings.Cut(h, "=")
+				if !ok {
+					return fmt.Errorf("bad header %q, e.g. key=value", h)
+				}
+				headers[k] = v
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+			defer cancel()
+			if err := pkg.PostOTLP(ctx, &http.Client{}, otlpEndpoint, headers, traces); err != nil {
+				return err
+			}
+			logrus.WithField("endpoint", otlpEndpoint).Infof("export %d services", len(traces.ResourceSpans))
+		}
+
+		switch {
+		case otlpFile == "-" || otlpFile == "" && otlpEndpoint == "":
+			if err := pkg.WriteOTLP(os.Stdout, traces); err != nil {
+				return err
+			}
+		case otlpFile != "":
+			if err := pkg.WriteOTLPFile(otlpFile, traces); err != nil {
+				return err
+			}
+			logrus.WithField("file", otlpFile).Infof("export %d services", len(traces.ResourceSpans))
+		}
+		return nil
+	},
+}
+
+func init() {
+	flags := otlpCmd.Flags()
+	flags.StringVar(&otlpFile, "file", "", "write the traces into file, `-` or none for stdout")
+	flags.StringVar(&otlpEndpoint, "endpoint", "", "post the traces to OTLP/HTTP endpoint, e.g. `http://localhost:4318`")
+	flags.StringArrayVar(&otlpHeaders, "header", nil, "extra http header of the post, `key=value`")
+	flags.DurationVar(&otlpTimeout, "timeout", 10*time.Second, "timeout of the post")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/FFengIll/pstopo/pkg"
+)
+
+var redactOutput = ""
+
+var redactCmd = &cobra.Command{
+	Use:   "redact in.json -o out.json",
+	Short: "mask secrets and pseudonymise ips, hostnames and users of a snapshot for sharing",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		snapshot, err := pkg.LoadSnapshot(args[0])
+		if err != nil {
+			return err
+		}
+		if err := redactSnapshot(snapshot); err != nil {
+			return err
+		}
+		if redactOutput == "" || redactOutput == "-" {
+			return snapshot.Encode(os.Stdout)
+		}
+		return snapshot.DumpFile(redactOutput)
+	},
+}
+
+// redactSnapshot redacts the snapshot with the options, and keeps the mapping if given
+func redactSnapshot(snapshot *pkg.Snapshot) error {
+	mapping := pkg.NewRedactMapping()
+	if redactMap != "" && existFile(redactMap) {
+		var err error
+		if mapping, err = pkg.LoadRedactMapping(redactMap); err != nil {
+			return err
+		}
+	}
+
+	redactor := pkg.NewRedactor(mapping)
+	redactor.StripCmdline = stripCmdline
+	redactor.Redact(snapshot)
+
+	if redactMap != "" {
+		logrus.WithField("mapping", redactMap).Infoln("save redact mapping, keep it private")
+		return mapping.DumpFile(redactMap)
+	}
+	return nil
+}
+
+func init() {
+	flags := redactCmd.Flags()
+	flags.StringVarP(&redactOutput, "output", "o", "", "redacted snapshot file path, stdout if none")
+}
@@ -2,14 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
-	mapset "github.com/deckarep/golang-set/v2"
-	jsoniter "github.com/json-iterator/go"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
@@ -17,32 +18,51 @@ import (
 )
 
 var reloadCmd = &cobra.Command{
-	Use: "reload",
-	Run: func(cmd *cobra.Command, args []string) {
-		var json = jsoniter.ConfigCompatibleWithStandardLibrary
-
+	Use:  "reload dir [filter ...]",
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		outputDir := args[0]
 		if outputDir == "" {
-			panic("no given name")
+			return errors.New("no given name")
 		}
 
-		snapshotPath := path.Join(outputDir, "snapshot.json")
-		configPath := path.Join(outputDir, "config.json")
+		snapshotPath := defaultSnapshotPath(outputDir)
+		configPath := defaultConfigPath(outputDir)
 		outputPath := path.Join(outputDir, "output.dot")
 
-		snapshot := &pkg.Snapshot{}
-		data, _ := os.ReadFile(snapshotPath)
-		err := json.Unmarshal(data, snapshot)
-		if err != nil {
-			panic(err)
+		var snapshot *pkg.Snapshot
+		var err error
+		if reloadAt != "" {
+			// rebuild the snapshot from history
+			at, err := parseTime(reloadAt)
+			if err != nil {
+				return err
+			}
+			if historyPath == "" {
+				historyPath = path.Join(outputDir, "history.jsonl")
+			}
+			frames, err := pkg.ReadHistory(historyPath)
+			if err != nil {
+				return err
+			}
+			var captured time.Time
+			snapshot, captured, err = pkg.ReplayHistory(frames, at)
+			if err != nil {
+				return err
+			}
+			logrus.Infof("reload the capture at %s", captured.Format(time.RFC3339))
+		} else {
+			snapshot, err = pkg.LoadSnapshot(snapshotPath)
+			if err != nil {
+				return err
+			}
 		}
 
 		config := pkg.NewConfig()
 		if existFile(configPath) {
-			data, _ = os.ReadFile(configPath)
-			err = json.Unmarshal(data, &config)
+			config, err = pkg.LoadConfig(configPath)
 			if err != nil {
-				panic(err)
+				return err
 			}
 		} else {
 			logrus.Warningln("no such config, use empty")
@@ -62,6 +82,18 @@ var reloadCmd = &cobra.Command{
 				}
 			}
 
+			if strings.HasPrefix(arg, "container:") {
+				config.Container = append(config.Container, strings.TrimPrefix(arg, "container:"))
+				logrus.Infof("add container: %s", arg)
+				continue
+			}
+
+			if strings.HasPrefix(arg, "unit:") {
+				config.Unit = append(config.Unit, strings.TrimPrefix(arg, "unit:"))
+				logrus.Infof("add unit: %s", arg)
+				continue
+			}
+
 			ip := net.ParseIP(arg)
 			if ip != nil {
 				logrus.Warningf("(NOT IMPLEMENTED) add ip: %s", ip)
@@ -71,45 +103,78 @@ var reloadCmd = &cobra.Command{
 			config.Cmd = append(config.Cmd, arg)
 		}
 
-		if len(config.Cmd) <= 0 {
+		if len(config.Cmd) <= 0 && len(config.Container) <= 0 && len(config.Unit) <= 0 {
 			config.All = true
 		} else {
 			config.All = false
 		}
 
+		if err := applyOptions(config); err != nil {
+			return err
+		}
+
 		var topo *pkg.PSTopo
 		topo = pkg.NewTopo(snapshot)
 		topo = topo.Analyse(config)
-		render, _ := pkg.NewDotRender()
-		render.Write(topo, outputPath)
+		if err := renderOutput(topo, outputPath); err != nil {
+			return err
+		}
 		if update {
-			logrus.Infoln("overwrite snapshot")
-			snapshot.DumpFile(snapshotPath)
+			if reloadAt == "" {
+				logrus.Infoln("overwrite snapshot")
+				if err := snapshot.DumpFile(snapshotPath); err != nil {
+					return fmt.Errorf("save snapshot: %w", err)
+				}
+			}
 
-			logrus.Infoln("overwrite config")
-			dumpConfigFile(config, configPath)
+			if existFile(configPath) && pkg.ConfigFormat(configPath) != pkg.ConfigJSON {
+				// yaml and toml are written by hand with comments
+				logrus.WithField("config", configPath).Warningln("not overwrite config in yaml or toml")
+			} else {
+				logrus.Infoln("overwrite config")
+				if err := dumpConfigFile(config, configPath); err != nil {
+					return fmt.Errorf("save config: %w", err)
+				}
+			}
 		}
+		return nil
 	},
 }
 
-func dumpConfigFile(config *pkg.Config, configPath string) {
-	cmdSet := mapset.NewSet[string]()
+func dumpConfigFile(config *pkg.Config, configPath string) error {
+	// dedup in the given order, so the file is the same over runs
+	seen := map[string]bool{}
+	cmds := []string{}
 	for _, c := range config.Cmd {
-		cmdSet.Add(c)
+		if !seen[c] {
+			seen[c] = true
+			cmds = append(cmds, c)
+		}
 	}
-	config.Cmd = cmdSet.ToSlice()
+	config.Cmd = cmds
 
-	// dump config
-	data, err := json.MarshalIndent(config, "", "  ")
-	if err != nil {
-		return
+	// dump config, keep json indented for hand editing
+	if pkg.ConfigFormat(configPath) != pkg.ConfigJSON {
+		if err := config.WriteTo(configPath); err != nil {
+			return err
+		}
+	} else {
+		data, err := json.MarshalIndent(config, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(configPath, data, 0644); err != nil {
+			return err
+		}
 	}
-	os.WriteFile(configPath, data, os.ModePerm)
 
 	logrus.Infof("config to: %s\n", configPath)
+	return nil
 }
 
 func init() {
 	flags := reloadCmd.PersistentFlags()
 	flags.BoolVarP(&update, "update", "w", false, "update and rewrite related file if possible")
+	flags.StringVar(&reloadAt, "at", "", "reload the topo as it was at the time from history, e.g. `2026-10-18T10:00`")
+	flags.StringVar(&historyPath, "history", "", "history file path, default may use `history.jsonl` in output dir")
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/FFengIll/pstopo/pkg"
+)
+
+var listenAddr = ""
+var cacheTTL = time.Duration(0)
+var serveToken = ""
+var serveInsecure = false
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "serve the snapshot and topo over http",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token, err := requireToken(serveToken, serveInsecure)
+		if err != nil {
+			return err
+		}
+		server := pkg.NewServer(snapshotPath, connectionKind, cacheTTL)
+		server.Token = token
+		defer server.Close()
+		logrus.WithField("listen", listenAddr).Infoln("serve http")
+		if err := http.ListenAndServe(listenAddr, server); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	flags := serveCmd.Flags()
+	flags.StringVar(&listenAddr, "listen", ":7070", "http listen address")
+	flags.DurationVar(&cacheTTL, "ttl", 10*time.Second, "reuse the fresh snapshot within the ttl")
+	flags.StringVar(&serveToken, "token", "", "shared token, or env `PSTOPO_TOKEN`")
+	flags.BoolVar(&serveInsecure, "insecure", false, "serve without token, anyone reaching the address can fetch the snapshot")
+}
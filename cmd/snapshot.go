@@ -1,27 +1,146 @@
 package main
 
 import (
-	"github.com/FFengIll/pstopo/pkg"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"time"
+
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+
+	"github.com/FFengIll/pstopo/pkg"
 )
 
 var snapshotCmd = &cobra.Command{
 	Use: "snapshot",
-	Run: func(cmd *cobra.Command, args []string) {
-		executeSnapshot()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return executeSnapshot()
 	},
 }
 
-func executeSnapshot() {
-	snapshot, err := pkg.TakeSnapshot(connectionKind)
+// snapshotNames are looked up in the output dir by order, if no snapshot is given
+var snapshotNames = []string{"snapshot.json", "snapshot.json.zst", "snapshot.json.gz"}
+
+// defaultSnapshotPath is the first existing snapshot in the dir, or `snapshot.json` in it
+func defaultSnapshotPath(dir string) string {
+	for _, name := range snapshotNames {
+		if p := path.Join(dir, name); existFile(p) {
+			return p
+		}
+	}
+	return path.Join(dir, "snapshot.json")
+}
+
+func executeSnapshot() error {
+	snapshot, err := takeSnapshot()
 	if err != nil {
-		panic(err)
+		return err
 	}
 	snapshotPath = fixSnapshotPath(snapshotPath)
-	snapshot.DumpFile(snapshotPath)
+	return snapshot.DumpFile(snapshotPath)
+}
+
+// takeSnapshot captures current system, with the sampled connections and the remote names if required
+func takeSnapshot() (*pkg.Snapshot, error) {
+	return takeSnapshotFor(nil)
+}
+
+// takeSnapshotFor captures only the processes of the config with `--targeted`, or all of current system
+func takeSnapshotFor(config *pkg.Config) (*pkg.Snapshot, error) {
+	// stop on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var samples map[string]*pkg.ConnectionSample
+	polls := 0
+	if sampleWindow > 0 {
+		logrus.WithField("window", sampleWindow).Infoln("sample connections")
+		var err error
+		samples, polls, err = pkg.NewSampler(connectionKind, sampleWindow, sampleEvery).Sample(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if snapshotTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, snapshotTimeout)
+		defer cancel()
+	}
+	var snapshot *pkg.Snapshot
+	var err error
+	if targeted && config != nil {
+		snapshot, err = pkg.TakeTargetedSnapshot(ctx, connectionKind, config, snapshotWorkers)
+	} else {
+		snapshot, err = pkg.TakeSnapshotWithContext(ctx, connectionKind, snapshotWorkers)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("take snapshot: %w", err)
+	}
+	if summary := snapshot.PermissionSummary(5); summary != "" {
+		logrus.Warningln(summary + ", run as root for the full topo")
+	}
+	if samples != nil {
+		snapshot.AddSamples(samples, polls)
+	}
+
+	if err := resolveNames(snapshot); err != nil {
+		return nil, err
+	}
+
+	// after resolving, the names are redacted too
+	if redact {
+		if err := redactSnapshot(snapshot); err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+// resolveNames resolves the remote ip with the name options
+func resolveNames(snapshot *pkg.Snapshot) error {
+	if hostsPath == "" && namesPath == "" && !reverseDNS {
+		return nil
+	}
+
+	resolver := pkg.NewResolver()
+	if hostsPath != "" {
+		if err := resolver.LoadHosts(hostsPath); err != nil {
+			logrus.WithError(err).WithField("hosts", hostsPath).Warningln("load hosts error")
+		}
+	}
+	if namesPath != "" {
+		if err := resolver.LoadMapping(namesPath); err != nil {
+			return err
+		}
+	}
+	if reverseDNS {
+		resolver.EnableDNS(dnsTimeout)
+	}
+	snapshot.ResolveNames(resolver)
+	return nil
+}
+
+// loadOrTakeSnapshot loads the snapshot of the first arg, or takes a fresh one
+func loadOrTakeSnapshot(args []string) (*pkg.Snapshot, error) {
+	if len(args) > 0 {
+		return pkg.LoadSnapshot(args[0])
+	}
+	return takeSnapshot()
 }
 
 func init() {
 	flags := snapshotCmd.PersistentFlags()
 	flags.StringVarP(&snapshotPath, "output", "o", "", "cache snapshot to file")
+	addSampleFlags(snapshotCmd)
+}
+
+// addSampleFlags adds the sampling options to a command which takes snapshot
+func addSampleFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.DurationVar(&sampleWindow, "sample", 0, "sample connections over the window before capture, e.g. `10s`")
+	flags.DurationVar(&sampleEvery, "every", 100*time.Millisecond, "interval between samples")
 }
@@ -5,14 +5,16 @@ go 1.22.0
 toolchain go1.22.8
 
 require (
-	github.com/deckarep/golang-set/v2 v2.6.0
+	github.com/BurntSushi/toml v1.5.0
 	github.com/goccy/go-graphviz v0.2.9
 	github.com/json-iterator/go v1.1.12
+	github.com/klauspost/compress v1.18.0
 	github.com/shirou/gopsutil/v3 v3.24.5
 	github.com/sirupsen/logrus v1.9.3
 	github.com/spf13/afero v1.11.0
 	github.com/spf13/cobra v1.8.1
 	gonum.org/v1/gonum v0.15.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,195 @@
+package pkg
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	gonet "net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const tokenPrefix = "Bearer "
+
+// Agent serves the current snapshot of the host, protected by a shared token.
+type Agent struct {
+	Kind  string
+	Token string
+
+	take func(kind string) (*Snapshot, error)
+	mux  *http.ServeMux
+}
+
+func NewAgent(kind string, token string) *Agent {
+	a := &Agent{
+		Kind:  kind,
+		Token: token,
+		take:  TakeSnapshot,
+		mux:   http.NewServeMux(),
+	}
+	a.mux.HandleFunc("GET /snapshot", a.handleSnapshot)
+	return a
+}
+
+func (a *Agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, a.Token) {
+		logrus.WithField("remote", r.RemoteAddr).Warningln("unauthorized request")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+// authorized checks the bearer token of the request, any request is allowed without token
+func authorized(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, tokenPrefix) {
+		return false
+	}
+	given := strings.TrimPrefix(header, tokenPrefix)
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+func (a *Agent) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := a.take(a.Kind)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := snapshot.Dump()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// CollectResult is the result of collecting from an agent
+type CollectResult struct {
+	From string
+	Path string
+	Err  error
+}
+
+// Collector fetches snapshots from agents in parallel.
+type Collector struct {
+	Token   string
+	Timeout time.Duration
+	Client  *http.Client
+	// compression of the written snapshots, CompressGzip or CompressZstd, none by default
+	Compress string
+}
+
+func NewCollector(token string, timeout time.Duration) *Collector {
+	return &Collector{
+		Token:   token,
+		Timeout: timeout,
+		Client:  &http.Client{},
+	}
+}
+
+// Fetch gets the snapshot from an agent, e.g. `host1:7071` or `http://host1:7071`
+func (c *Collector) Fetch(ctx context.Context, from string) (*Snapshot, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	url := from
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(url, "/")+"/snapshot", nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", tokenPrefix+c.Token)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	snapshot, err := DecodeSnapshot(resp.Body)
+	if err != nil {
+		return nil, &DecodeError{Path: url, Err: err}
+	}
+
+	// the address we reach is also the address of the host, for merge
+	host := hostOf(url)
+	if ip := gonet.ParseIP(host); ip != nil && !contains(snapshot.Addrs, ip.String()) {
+		snapshot.Addrs = append(snapshot.Addrs, ip.String())
+	}
+	return snapshot, nil
+}
+
+// Collect fetches all agents in parallel and writes the snapshots into dir,
+// the result is in the same order of `froms`
+func (c *Collector) Collect(ctx context.Context, froms []string, dir string) []*CollectResult {
+	results := make([]*CollectResult, len(froms))
+	var wg sync.WaitGroup
+	for i, from := range froms {
+		wg.Add(1)
+		go func(i int, from string) {
+			defer wg.Done()
+			result := &CollectResult{From: from}
+			results[i] = result
+
+			snapshot, err := c.Fetch(ctx, from)
+			if err != nil {
+				result.Err = err
+				return
+			}
+			result.Path = filepath.Join(dir, replaceIPChar(hostPortOf(from))+".snapshot.json"+compressExt[c.Compress])
+			result.Err = snapshot.writeFile(result.Path)
+		}(i, from)
+	}
+	wg.Wait()
+	return results
+}
+
+func hostPortOf(url string) string {
+	if i := strings.Index(url, "://"); i >= 0 {
+		url = url[i+3:]
+	}
+	return strings.SplitN(url, "/", 2)[0]
+}
+
+func hostOf(url string) string {
+	hostPort := hostPortOf(url)
+	host, _, err := gonet.SplitHostPort(hostPort)
+	if err != nil {
+		return hostPort
+	}
+	return host
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
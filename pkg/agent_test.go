@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAgent(host string, delay time.Duration) *httptest.Server {
+	agent := NewAgent("all", "secret")
+	agent.take = func(kind string) (*Snapshot, error) {
+		time.Sleep(delay)
+		snapshot := externalSnapshot()
+		snapshot.Host = host
+		return snapshot, nil
+	}
+	return httptest.NewServer(agent)
+}
+
+func TestAgentCollect(t *testing.T) {
+	a := newTestAgent("host-a", 0)
+	defer a.Close()
+	b := newTestAgent("host-b", 0)
+	defer b.Close()
+	slow := newTestAgent("slow", time.Second)
+	defer slow.Close()
+
+	// no token
+	resp, err := http.Get(a.URL + "/snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got %d without token, want 401", resp.StatusCode)
+	}
+
+	// the token without the scheme
+	req, _ := http.NewRequest(http.MethodGet, a.URL+"/snapshot", nil)
+	req.Header.Set("Authorization", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got %d without bearer scheme, want 401", resp.StatusCode)
+	}
+
+	dir := t.TempDir()
+	froms := []string{a.URL, strings.TrimPrefix(b.URL, "http://"), slow.URL}
+	results := NewCollector("secret", 200*time.Millisecond).Collect(context.Background(), froms, dir)
+
+	for i, host := range []string{"host-a", "host-b"} {
+		if results[i].Err != nil {
+			t.Fatalf("collect %s: %v", results[i].From, results[i].Err)
+		}
+		snapshot, err := LoadSnapshot(results[i].Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snapshot.Host != host || !contains(snapshot.Addrs, "127.0.0.1") {
+			t.Errorf("bad snapshot from %s: %s %v", results[i].From, snapshot.Host, snapshot.Addrs)
+		}
+	}
+	if results[2].Err == nil {
+		t.Error("expect timeout of slow agent")
+	}
+
+	collector := NewCollector("secret", time.Second)
+	collector.Compress = CompressZstd
+	results = collector.Collect(context.Background(), froms[:1], dir)
+	if results[0].Err != nil || !strings.HasSuffix(results[0].Path, ".snapshot.json.zst") {
+		t.Fatalf("collect compressed: %s %v", results[0].Path, results[0].Err)
+	}
+	if snapshot, err := LoadSnapshot(results[0].Path); err != nil || snapshot.Host != "host-a" {
+		t.Errorf("load compressed: %v", err)
+	}
+
+	results = NewCollector("wrong", time.Second).Collect(context.Background(), froms[:1], dir)
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "401") {
+		t.Errorf("expect unauthorized, got %v", results[0].Err)
+	}
+}
@@ -0,0 +1,182 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+const (
+	DriftNewListener       = "new-listener"
+	DriftMissingListener   = "missing-listener"
+	DriftNewDestination    = "new-destination"
+	DriftMissingDependency = "missing-dependency"
+	baselineUnknownProcess = "?"
+)
+
+// BaselineListener is a service listening on a port
+type BaselineListener struct {
+	Service string `json:"service"`
+	Addr    string `json:"addr"`
+	Port    uint32 `json:"port"`
+}
+
+func (l BaselineListener) String() string {
+	return fmt.Sprintf("%s listen %s:%d", l.Service, l.Addr, l.Port)
+}
+
+// BaselineEdge is a dependency of a service, to another service or to a remote host
+type BaselineEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Port uint32 `json:"port"`
+}
+
+func (e BaselineEdge) String() string {
+	return fmt.Sprintf("%s -> %s:%d", e.From, e.To, e.Port)
+}
+
+// Baseline is a normalised topo, without pids and ephemeral ports,
+// so it keeps the same over restarts
+type Baseline struct {
+	Host      string             `json:"host,omitempty"`
+	Services  []string           `json:"services"`
+	Listeners []BaselineListener `json:"listeners"`
+	Edges     []BaselineEdge     `json:"edges"`
+}
+
+// Drift is a change of the topo against the baseline
+type Drift struct {
+	Kind string `json:"kind"`
+	Item string `json:"item"`
+}
+
+func serviceName(p *Process) string {
+	if p == nil {
+		return baselineUnknownProcess
+	}
+	return processName(p)
+}
+
+// NewBaseline normalises the snapshot into a baseline
+func NewBaseline(snapshot *Snapshot) *Baseline {
+	items := policyItems(snapshot)
+	services := map[string]bool{}
+	listeners := map[BaselineListener]bool{}
+	edges := map[BaselineEdge]bool{}
+
+	for _, item := range items[RuleListen] {
+		l := BaselineListener{Service: serviceName(item.Process), Addr: item.Addr, Port: item.Port}
+		listeners[l] = true
+		services[l.Service] = true
+	}
+	cfg := NewConfig()
+	cfg.Cmd = []string{"*"}
+	for _, e := range NewTopo(snapshot).Analyse(cfg).ServiceEdges() {
+		edges[e] = true
+		services[e.From] = true
+	}
+	delete(services, baselineUnknownProcess)
+
+	b := &Baseline{Host: snapshot.Host, Services: []string{}, Listeners: []BaselineListener{}, Edges: []BaselineEdge{}}
+	for s := range services {
+		b.Services = append(b.Services, s)
+	}
+	for l := range listeners {
+		b.Listeners = append(b.Listeners, l)
+	}
+	for e := range edges {
+		b.Edges = append(b.Edges, e)
+	}
+	sort.Strings(b.Services)
+	sort.Slice(b.Listeners, func(i, j int) bool { return b.Listeners[i].String() < b.Listeners[j].String() })
+	sort.Slice(b.Edges, func(i, j int) bool { return b.Edges[i].String() < b.Edges[j].String() })
+	return b
+}
+
+// ServiceEdges returns the distinct dependencies between services of the topo,
+// to the remote process, or to the resolved name or the ip if not local
+func (tp *PSTopo) ServiceEdges() []BaselineEdge {
+	return tp.serviceEdges(false)
+}
+
+// serviceEdges returns the service edges of the connections, or only of the outbound ones
+func (tp *PSTopo) serviceEdges(outbound bool) []BaselineEdge {
+	seen := map[BaselineEdge]bool{}
+	var edges []BaselineEdge
+	for _, item := range tp.connectItems(outbound) {
+		e := BaselineEdge{From: serviceName(item.Process), Port: item.Port}
+		if item.RemoteProcess != nil {
+			e.To = serviceName(item.RemoteProcess)
+		} else if name := tp.Snapshot.HostName(item.Addr); name != "" {
+			e.To = name
+		} else {
+			e.To = item.Addr
+		}
+		if !seen[e] {
+			seen[e] = true
+			edges = append(edges, e)
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool { return edges[i].String() < edges[j].String() })
+	return edges
+}
+
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &Baseline{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, &DecodeError{Path: path, Err: err}
+	}
+	return b, nil
+}
+
+func (b *Baseline) DumpFile(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Drift reports the current topo against the baseline:
+// new listeners and new destinations, and the listeners and dependencies gone
+func (b *Baseline) Drift(current *Baseline) []*Drift {
+	var drifts []*Drift
+
+	listeners := map[BaselineListener]bool{}
+	for _, l := range b.Listeners {
+		listeners[l] = true
+	}
+	for _, l := range current.Listeners {
+		if !listeners[l] {
+			drifts = append(drifts, &Drift{Kind: DriftNewListener, Item: l.String()})
+		}
+		delete(listeners, l)
+	}
+	for _, l := range b.Listeners {
+		if listeners[l] {
+			drifts = append(drifts, &Drift{Kind: DriftMissingListener, Item: l.String()})
+		}
+	}
+
+	edges := map[BaselineEdge]bool{}
+	for _, e := range b.Edges {
+		edges[e] = true
+	}
+	for _, e := range current.Edges {
+		if !edges[e] {
+			drifts = append(drifts, &Drift{Kind: DriftNewDestination, Item: e.String()})
+		}
+		delete(edges, e)
+	}
+	for _, e := range b.Edges {
+		if edges[e] {
+			drifts = append(drifts, &Drift{Kind: DriftMissingDependency, Item: e.String()})
+		}
+	}
+	return drifts
+}
@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func baselineSnapshot(pid int32, ephemeral uint32) *Snapshot {
+	snapshot := NewSnapshot()
+	snapshot.PidProcess[pid] = &Process{Pid: pid, Name: "app", Exec: "/usr/bin/app", Cmdline: "app"}
+	snapshot.PidProcess[pid+1] = &Process{Pid: pid + 1, Name: "postgres", Exec: "/usr/bin/postgres", Cmdline: "postgres"}
+	for p := range snapshot.PidProcess {
+		snapshot.PidPort[p] = NewPortSet()
+		snapshot.PidListenPort[p] = NewPortSet()
+	}
+	conns := []net.ConnectionStat{
+		{Pid: pid + 1, Status: "LISTEN", Laddr: net.Addr{IP: "127.0.0.1", Port: 5432}},
+		{Pid: pid, Status: "ESTABLISHED", Laddr: net.Addr{IP: "127.0.0.1", Port: ephemeral}, Raddr: net.Addr{IP: "127.0.0.1", Port: 5432}},
+		{Pid: pid + 1, Status: "ESTABLISHED", Laddr: net.Addr{IP: "127.0.0.1", Port: 5432}, Raddr: net.Addr{IP: "127.0.0.1", Port: ephemeral}},
+		{Pid: pid, Status: "ESTABLISHED", Laddr: net.Addr{IP: "192.168.1.2", Port: ephemeral + 1}, Raddr: net.Addr{IP: "8.8.8.8", Port: 53}},
+	}
+	for _, conn := range conns {
+		snapshot.addConnection(&snapshot.PortIndex, conn)
+	}
+	snapshot.Hostnames["8.8.8.8"] = "dns.google"
+	return snapshot
+}
+
+func TestBaselineDrift(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	if err := NewBaseline(baselineSnapshot(100, 40000)).DumpFile(path); err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := LoadBaseline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(baseline.Services) != 2 || len(baseline.Listeners) != 1 || len(baseline.Edges) != 2 {
+		t.Fatalf("bad baseline %+v", baseline)
+	}
+
+	// restarted with other pids and ephemeral ports
+	if drifts := baseline.Drift(NewBaseline(baselineSnapshot(200, 50000))); len(drifts) != 0 {
+		t.Errorf("expect no drift, got %v", drifts)
+	}
+
+	snapshot := baselineSnapshot(200, 50000)
+	snapshot.addConnection(&snapshot.PortIndex, net.ConnectionStat{Pid: 200, Status: "LISTEN",
+		Laddr: net.Addr{IP: "0.0.0.0", Port: 4444}})
+	delete(snapshot.PortConnection, 50001)
+	delete(snapshot.PortPid, 50001)
+
+	drifts := baseline.Drift(NewBaseline(snapshot))
+	want := map[string]string{
+		DriftNewListener:       "app listen 0.0.0.0:4444",
+		DriftMissingDependency: "app -> dns.google:53",
+	}
+	if len(drifts) != len(want) {
+		t.Fatalf("got drifts %v, want %v", drifts, want)
+	}
+	for _, d := range drifts {
+		if want[d.Kind] != d.Item {
+			t.Errorf("got %s %s, want %s", d.Kind, d.Item, want[d.Kind])
+		}
+	}
+}
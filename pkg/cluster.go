@@ -0,0 +1,46 @@
+package pkg
+
+const (
+	ClusterByContainer = "container"
+	ClusterByUnit      = "unit"
+	ClusterByNone      = "none"
+)
+
+// ProcessCluster is a group of processes shown together in output,
+// e.g. all processes of a container
+type ProcessCluster struct {
+	ID    string
+	Label string
+	Pids  []int32
+}
+
+// clusterKey returns the cluster id and label of a process, "" for no cluster
+func clusterKey(by string, p *Process) (string, string) {
+	switch by {
+	case "", ClusterByContainer:
+		if p.ContainerID == "" {
+			return "", ""
+		}
+		return p.ContainerID, p.Runtime + " " + ShortContainerID(p.ContainerID)
+	case ClusterByUnit:
+		return p.Unit, p.Unit
+	}
+	return "", ""
+}
+
+func (tp *PSTopo) clusterProcess(cfg *Config) {
+	// in order of pid, so the pids of cluster are sorted
+	for _, p := range tp.Processes() {
+		pid := p.Pid
+		id, label := clusterKey(cfg.Cluster, p)
+		if id == "" {
+			continue
+		}
+		cluster, ok := tp.ClusterSet[id]
+		if !ok {
+			cluster = &ProcessCluster{ID: id, Label: label}
+			tp.ClusterSet[id] = cluster
+		}
+		cluster.Pids = append(cluster.Pids, pid)
+	}
+}
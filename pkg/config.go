@@ -1,16 +1,36 @@
 package pkg
 
 import (
+	"bytes"
+	"fmt"
 	"os"
+	"strings"
 
+	"github.com/BurntSushi/toml"
 	jsoniter "github.com/json-iterator/go"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	All  bool     `json:"all" default:"false"`
-	Cmd  []string `json:"cmd"`
-	Port []uint32 `json:"port"`
-	Pid  []int32  `json:"pid"`
+	All  bool     `json:"all" yaml:"all" toml:"all" default:"false"`
+	Cmd  []string `json:"cmd" yaml:"cmd" toml:"cmd"`
+	Port []uint32 `json:"port" yaml:"port" toml:"port"`
+	Pid  []int32  `json:"pid" yaml:"pid" toml:"pid"`
+
+	// filter by container id (or its prefix)
+	Container []string `json:"container" yaml:"container" toml:"container"`
+
+	// filter by systemd unit, e.g. `nginx.service` or `nginx`
+	Unit []string `json:"unit" yaml:"unit" toml:"unit"`
+
+	// cluster nodes in output by `container` (default), `unit`, or `none`
+	Cluster string `json:"cluster" yaml:"cluster" toml:"cluster"`
+
+	// group external ip by the networks (cidr to name), or by the prefix block of ipv4 and ipv6
+	Group        bool              `json:"group" yaml:"group" toml:"group"`
+	Networks     map[string]string `json:"networks" yaml:"networks" toml:"networks"`
+	GroupPrefix  int               `json:"group_prefix" yaml:"group_prefix" toml:"group_prefix"`
+	GroupPrefix6 int               `json:"group_prefix6" yaml:"group_prefix6" toml:"group_prefix6"`
 }
 
 func NewConfig() *Config {
@@ -19,19 +39,118 @@ func NewConfig() *Config {
 		Cmd:  []string{},
 		Port: []uint32{},
 		Pid:  []int32{},
+
+		Container: []string{},
+		Unit:      []string{},
 	}
 }
 
-func (c *Config) WriteTo(path string) {
-	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+// WriteTo writes the config in the format of the path, see ConfigFormat
+func (c *Config) WriteTo(path string) error {
+	var data []byte
+	var err error
+	switch ConfigFormat(path) {
+	case ConfigYAML:
+		data, err = yaml.Marshal(c)
+	case ConfigTOML:
+		var buf bytes.Buffer
+		err = toml.NewEncoder(&buf).Encode(c)
+		data = buf.Bytes()
+	default:
+		var json = jsoniter.ConfigCompatibleWithStandardLibrary
+		data, err = json.Marshal(c)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, os.ModePerm)
+}
 
-	data, err := json.Marshal(c)
+// LoadConfig reads a config file in json, yaml or toml by the extension,
+// and validates it against the schema, see ConfigSchema
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format := ConfigFormat(path)
+	root, err := parseConfigValue(format, data)
 	if err != nil {
-		panic(err)
+		return nil, &DecodeError{Path: path, Err: err}
+	}
+	if errs := validateConfig(root); len(errs) > 0 {
+		return nil, &ConfigErrors{Path: path, Errors: errs}
 	}
 
-	err = os.WriteFile(path, data, os.ModePerm)
+	config := NewConfig()
+	switch format {
+	case ConfigYAML:
+		err = yaml.Unmarshal(data, config)
+	case ConfigTOML:
+		_, err = toml.Decode(string(data), config)
+	default:
+		err = json.Unmarshal(data, config)
+	}
 	if err != nil {
-		panic(err)
+		return nil, &DecodeError{Path: path, Err: err}
+	}
+	return config, nil
+}
+
+// DefaultConfigText is the default config in the format, commented by the schema in yaml and toml
+func DefaultConfigText(format string) ([]byte, error) {
+	if format == ConfigJSON {
+		var json = jsoniter.ConfigCompatibleWithStandardLibrary
+		data, err := json.MarshalIndent(NewConfig(), "", "  ")
+		return append(data, '\n'), err
+	}
+
+	comment := func(buf *bytes.Buffer, f *configField) {
+		fmt.Fprintf(buf, "# %s\n", f.Description)
+		if f.Key == "networks" {
+			if format == ConfigTOML {
+				buf.WriteString("# e.g. \"10.0.0.0/8\" = \"internal\"\n")
+			} else {
+				buf.WriteString("# e.g.\n#   10.0.0.0/8: internal\n")
+			}
+		}
+	}
+	literal := func(f *configField) string {
+		switch f.Type {
+		case "boolean":
+			return "false"
+		case "integer":
+			return "0"
+		case "string":
+			return `""`
+		case "array":
+			return "[]"
+		}
+		return "{}"
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# pstopo config, see `pstopo config schema` for the json schema\n")
+	var tables []*configField
+	for _, f := range configFields {
+		// toml tables have to be after the keys
+		if format == ConfigTOML && f.Type == "object" {
+			tables = append(tables, f)
+			continue
+		}
+		buf.WriteString("\n")
+		comment(&buf, f)
+		sep := ": "
+		if format == ConfigTOML {
+			sep = " = "
+		}
+		buf.WriteString(f.Key + sep + literal(f) + "\n")
+	}
+	for _, f := range tables {
+		buf.WriteString("\n")
+		comment(&buf, f)
+		buf.WriteString("[" + f.Key + "]\n")
 	}
+	return []byte(strings.TrimLeft(buf.String(), "\n")), nil
 }
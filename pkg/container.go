@@ -0,0 +1,173 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const defaultProcRoot = "/proc"
+
+// ProcFS reads process info from procfs directly,
+// root can be a fixture tree in test.
+type ProcFS struct {
+	Root string
+}
+
+func NewProcFS(root string) *ProcFS {
+	if root == "" {
+		root = defaultProcRoot
+	}
+	return &ProcFS{Root: root}
+}
+
+func (fs *ProcFS) path(pid int32, elem ...string) string {
+	return filepath.Join(append([]string{fs.Root, strconv.Itoa(int(pid))}, elem...)...)
+}
+
+// CgroupPaths returns the cgroup path of each hierarchy,
+// the key is the controllers, e.g. `cpu,cpuacct`, or "" for cgroup v2
+func (fs *ProcFS) CgroupPaths(pid int32) (map[string]string, error) {
+	fd, err := os.Open(fs.path(pid, "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	paths := map[string]string{}
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		paths[parts[1]] = parts[2]
+	}
+	return paths, scanner.Err()
+}
+
+// Namespace returns the inode of the namespace, e.g. `net`, `pid`
+func (fs *ProcFS) Namespace(pid int32, kind string) (uint64, error) {
+	link, err := os.Readlink(fs.path(pid, "ns", kind))
+	if err != nil {
+		return 0, err
+	}
+	// e.g. net:[4026531993]
+	var inode uint64
+	if _, err := fmt.Sscanf(link, kind+":[%d]", &inode); err != nil {
+		return 0, fmt.Errorf("bad namespace link %q: %w", link, err)
+	}
+	return inode, nil
+}
+
+// Ppid returns the parent pid from `stat`, the name may contain spaces or parens
+func (fs *ProcFS) Ppid(pid int32) (int32, error) {
+	data, err := os.ReadFile(fs.path(pid, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// pid (comm) state ppid ...
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("bad stat of pid %d", pid)
+	}
+	ppid, err := strconv.ParseInt(fields[1], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad stat of pid %d: %w", pid, err)
+	}
+	return int32(ppid), nil
+}
+
+// Cmdline returns the arguments joined by space, same as `gopsutil`
+func (fs *ProcFS) Cmdline(pid int32) (string, error) {
+	data, err := os.ReadFile(fs.path(pid, "cmdline"))
+	if err != nil {
+		return "", err
+	}
+	args := strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+	return strings.Join(args, " "), nil
+}
+
+// Inspect fills the container and namespace info of the process.
+func (fs *ProcFS) Inspect(p *Process) error {
+	paths, err := fs.CgroupPaths(p.Pid)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if id, runtime := parseContainer(path); id != "" {
+			p.ContainerID, p.Runtime = id, runtime
+			break
+		}
+	}
+
+	// systemd hierarchy, the unified one (v2) or the named one (v1)
+	for _, key := range []string{"", "name=systemd"} {
+		if path, ok := paths[key]; ok {
+			if unit := parseUnit(path); unit != "" {
+				p.Unit = unit
+				break
+			}
+		}
+	}
+
+	if inode, err := fs.Namespace(p.Pid, "net"); err == nil {
+		p.NetNS = inode
+	}
+	if inode, err := fs.Namespace(p.Pid, "pid"); err == nil {
+		p.PidNS = inode
+	}
+	return nil
+}
+
+var containerPatterns = []struct {
+	runtime string
+	pattern *regexp.Regexp
+}{
+	{"docker", regexp.MustCompile(`docker[-/]([0-9a-f]{64})`)},
+	{"containerd", regexp.MustCompile(`cri-containerd[-:]([0-9a-f]{64})`)},
+	{"cri-o", regexp.MustCompile(`crio[-:]([0-9a-f]{64})`)},
+	{"podman", regexp.MustCompile(`libpod-([0-9a-f]{64})`)},
+	{"containerd", regexp.MustCompile(`kubepods.*/([0-9a-f]{64})$`)},
+	{"lxc", regexp.MustCompile(`/lxc(?:\.payload)?[./]([^/]+)`)},
+}
+
+// parseContainer finds the container id and runtime from a cgroup path.
+func parseContainer(path string) (string, string) {
+	for _, p := range containerPatterns {
+		if m := p.pattern.FindStringSubmatch(path); m != nil {
+			return m[1], p.runtime
+		}
+	}
+	return "", ""
+}
+
+var unitSuffixes = []string{".service", ".scope", ".slice"}
+
+// parseUnit finds the owning systemd unit from a cgroup path, the deepest one wins,
+// e.g. `/system.slice/nginx.service` is `nginx.service`
+func parseUnit(path string) string {
+	unit := ""
+	for _, elem := range strings.Split(path, "/") {
+		for _, suffix := range unitSuffixes {
+			if strings.HasSuffix(elem, suffix) {
+				unit = elem
+			}
+		}
+	}
+	return unit
+}
+
+// ShortContainerID is the first 12 chars of id, same as `docker ps`
+func ShortContainerID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,114 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const dockerID = "3f4e5d6c7b8a9f0e1d2c3b4a5f6e7d8c9b0a1f2e3d4c5b6a7f8e9d0c1b2a3f4e"
+
+// writeProcFixture writes a fake `/proc/<pid>` with cgroup and namespace links
+func writeProcFixture(t *testing.T, root string, pid string, cgroup string, netns string, pidns string) {
+	t.Helper()
+	dir := filepath.Join(root, pid)
+	if err := os.MkdirAll(filepath.Join(dir, "ns"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cgroup"), []byte(cgroup), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Symlink("net:["+netns+"]", filepath.Join(dir, "ns", "net"))
+	os.Symlink("pid:["+pidns+"]", filepath.Join(dir, "ns", "pid"))
+}
+
+func TestProcFSInspect(t *testing.T) {
+	root := t.TempDir()
+	writeProcFixture(t, root, "100", "0::/system.slice/docker-"+dockerID+".scope\n", "4026532200", "4026532201")
+	writeProcFixture(t, root, "200", "12:pids:/kubepods/burstable/pod1234/"+strings.Repeat("a", 64)+"\n1:name=systemd:/\n", "4026532300", "4026532301")
+	writeProcFixture(t, root, "1", "0::/init.scope\n", "4026531993", "4026531836")
+	writeProcFixture(t, root, "300", "1:name=systemd:/system.slice/nginx.service/worker\n0::/\n", "4026531993", "4026531836")
+
+	fs := NewProcFS(root)
+	cases := []struct {
+		pid     int32
+		id      string
+		runtime string
+		netns   uint64
+		unit    string
+	}{
+		{100, dockerID, "docker", 4026532200, "docker-" + dockerID + ".scope"},
+		{200, strings.Repeat("a", 64), "containerd", 4026532300, ""},
+		{1, "", "", 4026531993, "init.scope"},
+		{300, "", "", 4026531993, "nginx.service"},
+	}
+	for _, c := range cases {
+		p := &Process{Pid: c.pid}
+		if err := fs.Inspect(p); err != nil {
+			t.Fatal(err)
+		}
+		if p.ContainerID != c.id || p.Runtime != c.runtime || p.NetNS != c.netns || p.Unit != c.unit {
+			t.Errorf("pid %d got (%s, %s, %d, %s), want (%s, %s, %d, %s)",
+				c.pid, p.ContainerID, p.Runtime, p.NetNS, p.Unit, c.id, c.runtime, c.netns, c.unit)
+		}
+	}
+
+	if err := fs.Inspect(&Process{Pid: 404}); err == nil {
+		t.Error("expect error for missing pid")
+	}
+}
+
+func TestClusterByContainer(t *testing.T) {
+	snapshot := NewSnapshot()
+	snapshot.PidProcess[1] = &Process{Pid: 1, Exec: "/sbin/init", Children: []int32{10}}
+	snapshot.PidProcess[10] = &Process{Pid: 10, Exec: "/usr/sbin/nginx", Cmdline: "nginx", Parent: 1,
+		ContainerID: dockerID, Runtime: "docker"}
+
+	cfg := NewConfig()
+	cfg.Container = []string{ShortContainerID(dockerID)}
+	topo := NewTopo(snapshot).Analyse(cfg)
+
+	cluster, ok := topo.ClusterSet[dockerID]
+	if !ok {
+		t.Fatal("no cluster of container")
+	}
+	if cluster.Label != "docker 3f4e5d6c7b8a" || len(cluster.Pids) != 1 || cluster.Pids[0] != 10 {
+		t.Errorf("bad cluster %+v", cluster)
+	}
+
+	data, err := (&DotRender{}).toData(topo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Clusters) != 1 || len(data.Clusters[0].Nodes) != 1 {
+		t.Fatalf("bad clusters %+v", data.Clusters)
+	}
+	buf, err := executeTemplate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `subgraph "cluster_`+dockerID+`"`) {
+		t.Errorf("no container cluster in output:\n%s", buf)
+	}
+}
+
+func TestClusterByUnit(t *testing.T) {
+	snapshot := NewSnapshot()
+	snapshot.PidProcess[10] = &Process{Pid: 10, Exec: "/usr/sbin/nginx", Unit: "nginx.service", Children: []int32{11}}
+	snapshot.PidProcess[11] = &Process{Pid: 11, Exec: "/usr/sbin/nginx", Unit: "nginx.service", Parent: 10}
+	snapshot.PidProcess[20] = &Process{Pid: 20, Exec: "/usr/bin/sshd", Unit: "ssh.service"}
+
+	cfg := NewConfig()
+	cfg.Unit = []string{"nginx"}
+	cfg.Cluster = ClusterByUnit
+	topo := NewTopo(snapshot).Analyse(cfg)
+
+	if _, ok := topo.PidSet[20]; ok {
+		t.Error("ssh.service should be filtered out")
+	}
+	cluster, ok := topo.ClusterSet["nginx.service"]
+	if !ok || len(cluster.Pids) != 2 {
+		t.Errorf("bad unit cluster %+v", cluster)
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
-	"time"
 
 	"github.com/goccy/go-graphviz"
+	"github.com/shirou/gopsutil/v3/net"
 	"github.com/sirupsen/logrus"
 )
 
@@ -42,12 +45,29 @@ func (n dotNode) String() string {
 	return fmt.Sprintf("%s [ label=\"%s\", %s ]", n.ID, n.Label, n.Attrs)
 }
 
+type dotCluster struct {
+	ID       string
+	Attrs    dotAttrs
+	Nodes    []*dotNode
+	Clusters []*dotCluster
+}
+
+func (c dotCluster) String() string {
+	return "cluster_" + c.ID
+}
+
 type dotAttrs map[string]string
 
+// List returns the attributes in order of key
 func (p dotAttrs) List() []string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 	var l []string
-	for k, v := range p {
-		l = append(l, fmt.Sprintf("%s=%q", k, v))
+	for _, k := range keys {
+		l = append(l, fmt.Sprintf("%s=%q", k, p[k]))
 	}
 	return l
 }
@@ -80,10 +100,27 @@ func toDotId(pid int32) string {
 	return "n" + strconv.Itoa(int(pid))
 }
 
+// toDotSafeId keeps only the letter and digit of a name for dot id
+func toDotSafeId(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// makeDotLabel joins the items and the parts of port, in order of port
 func makeDotLabel(parts map[int]string, items ...string) string {
+	ids := make([]int, 0, len(parts))
+	for id := range parts {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
 	var records = items
-	for id, label := range parts {
-		records = append(records, makeDotPortLabel(label, strconv.Itoa(id)))
+	for _, id := range ids {
+		records = append(records, makeDotPortLabel(parts[id], strconv.Itoa(id)))
 	}
 
 	internal := strings.Join(records, " | ")
@@ -93,72 +130,154 @@ func makeDotLabel(parts map[int]string, items ...string) string {
 type dotGraphData struct {
 	Title string
 	// Attrs   dotAttrs
-	// Cluster *dotCluster
-	Nodes   []*dotNode
-	Edges   []*dotEdge
-	Options map[string]string
+	Clusters []*dotCluster
+	Nodes    []*dotNode
+	Edges    []*dotEdge
+	Options  map[string]string
 }
 
+// DotRender renders the topo as dot source, or as image by graphviz
 type DotRender struct {
-	Render
+	mu     sync.Mutex
 	engine *graphviz.Graphviz
 }
 
-func NewDotRender() (Render, error) {
-	g, err := graphviz.New(context.Background())
-	if err != nil {
-		return nil, err
+func NewDotRender() (*DotRender, error) {
+	return &DotRender{}, nil
+}
+
+// dotFormats are the formats of dot renderer, with the content type
+var dotFormats = map[string]string{
+	"dot": "text/vnd.graphviz",
+	"svg": "image/svg+xml",
+	"png": "image/png",
+	"jpg": "image/jpeg",
+}
+
+// Close releases the graphviz engine if created, the renderer can still be used after it
+func (r *DotRender) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.engine == nil {
+		return nil
+	}
+	err := r.engine.Close()
+	r.engine = nil
+	return err
+}
+
+// getEngine creates the graphviz engine at the first image rendering, with r.mu held
+func (r *DotRender) getEngine() (*graphviz.Graphviz, error) {
+	if r.engine == nil {
+		g, err := graphviz.New(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		r.engine = g
 	}
-	return &DotRender{engine: g}, nil
+	return r.engine, nil
 }
 
-func (r *DotRender) writeData(data *dotGraphData, output string) {
+// executeTemplate generates the dot source of the data
+func executeTemplate(data *dotGraphData) (*bytes.Buffer, error) {
 	t := template.New("dot")
 	for _, s := range []string{tmplLegend, tmplCluster, tmplNode, tmplEdge, tmplGraph} {
 		if _, err := t.Parse(s); err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
 	var buf bytes.Buffer
 	if err := t.Execute(&buf, data); err != nil {
-		panic(err)
+		return nil, err
 	}
+	return &buf, nil
+}
 
+// renderData writes the dot source of the data, or the image rendered by graphviz
+func (r *DotRender) renderData(w io.Writer, data *dotGraphData, format string) error {
+	if format == "" {
+		format = "dot"
+	}
+	if _, ok := dotFormats[format]; !ok {
+		return &RenderError{Stage: "render", Output: format, Err: fmt.Errorf("unknown format %q", format)}
+	}
+
+	buf, err := executeTemplate(data)
+	if err != nil {
+		return &RenderError{Stage: "template", Err: err}
+	}
 	logrus.Debugln(buf.String())
+	if format == "dot" {
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return &RenderError{Stage: "write", Err: err}
+		}
+		return nil
+	}
+
+	// the engine is shared by the renders, e.g. of the requests of server
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	graph, err := graphviz.ParseBytes(buf.Bytes())
 	if err != nil {
-		panic(err)
+		return &RenderError{Stage: "parse", Err: err}
 	}
-	// FIXME: use parsed graph, not r.graph
+	defer graph.Close()
+	engine, err := r.getEngine()
+	if err != nil {
+		return &RenderError{Stage: "render", Output: format, Err: err}
+	}
+	if err := engine.Render(context.Background(), graph, graphviz.Format(format), w); err != nil {
+		return &RenderError{Stage: "render", Output: format, Err: err}
+	}
+	return nil
+}
+
+// writeData writes the dot file, and the png of it aside.
+// The `.dot` suffix is added to output if missing.
+func (r *DotRender) writeData(data *dotGraphData, output string) error {
 	if !strings.HasSuffix(output, ".dot") {
 		output = output + ".dot"
 	}
 
-	// output dot file
-	os.WriteFile(output, buf.Bytes(), os.ModePerm)
-
-	// if err := r.engine.RenderFilename(graph, graphviz.Format(graphviz.DOT), output); err != nil {
-	// 	//fd, _ := os.Open(output)
-	// 	//fd.WriteString(err.Error())
-	// 	logrus.Errorln(err)
-	// 	logrus.Errorln("parse graph error, but try to output the file")
-	// 	return
-	// }
+	// output dot file, and the dot file is kept if png fails
+	if err := writeFile(output, func(w io.Writer) error { return r.renderData(w, data, "dot") }); err != nil {
+		return err
+	}
+	return writeFile(output+".png", func(w io.Writer) error { return r.renderData(w, data, "png") })
+}
 
-	if err := r.engine.RenderFilename(context.Background(), graph, graphviz.PNG, output+".png"); err != nil {
-		// fd, _ := os.Open(output)
-		// fd.WriteString(err.Error())
-		logrus.Errorln(err)
-		logrus.Errorln("parse graph error, but try to output the file")
+// weightEdge labels the edge with the sampled hits, and widens it by the ratio of polls
+func weightEdge(edge *dotEdge, snapshot *Snapshot, conn net.ConnectionStat) {
+	sample := snapshot.SampleOf(conn)
+	if sample == nil || snapshot.SamplePolls <= 0 {
 		return
 	}
+	edge.Attrs["label"] = fmt.Sprintf("%d/%d", sample.Hits, snapshot.SamplePolls)
+	edge.Attrs["penwidth"] = fmt.Sprintf("%.1f", 1+4*float64(sample.Hits)/float64(snapshot.SamplePolls))
 }
 
 func (r *DotRender) toData(topo *PSTopo) (*dotGraphData, error) {
+	// create cluster
+	var clusters []*dotCluster
+	pidCluster := map[int32]*dotCluster{}
+	for _, c := range topo.Clusters() {
+		cluster := &dotCluster{
+			ID: toDotSafeId(c.ID),
+			Attrs: dotAttrs{
+				"label": c.Label,
+				"style": "dashed",
+			},
+		}
+		for _, pid := range c.Pids {
+			pidCluster[pid] = cluster
+		}
+		clusters = append(clusters, cluster)
+	}
+
 	// create node
 	var nodes []*dotNode
-	for _, n := range topo.PidSet {
+	for _, n := range topo.Processes() {
 		if n.Pid == 0 {
 			continue
 		}
@@ -179,7 +298,7 @@ func (r *DotRender) toData(topo *PSTopo) (*dotGraphData, error) {
 
 			set, ok := topo.Snapshot.PidPort[n.Pid]
 			if ok {
-				for port := range set.Iter() {
+				for _, port := range set.Ports() {
 					// if contains(related, port) {
 					parts[int(port)] = ":" + strconv.Itoa(int(port))
 					// }
@@ -191,7 +310,7 @@ func (r *DotRender) toData(topo *PSTopo) (*dotGraphData, error) {
 		{
 			set, ok := topo.Snapshot.PidListenPort[n.Pid]
 			if ok {
-				for port := range set.Iter() {
+				for _, port := range set.Ports() {
 					// if contains(related, port) {
 					parts[int(port)] = "Listen " + ":" + strconv.Itoa(int(port))
 					// }
@@ -204,12 +323,16 @@ func (r *DotRender) toData(topo *PSTopo) (*dotGraphData, error) {
 		label := makeDotLabel(parts, paths[len(paths)-1], pidLabel)
 		node.Label = label
 
+		if cluster, ok := pidCluster[n.Pid]; ok {
+			cluster.Nodes = append(cluster.Nodes, node)
+			continue
+		}
 		nodes = append(nodes, node)
 	}
 
 	// generate edge data
 	var edges []*dotEdge
-	for _, e := range topo.PidChildSet {
+	for _, e := range topo.ChildEdges() {
 		edge := newDotEdge()
 		edge.From = toDotId(e.From) + StoDotPort("p")
 		edge.To = toDotId(e.To) + StoDotPort("p")
@@ -217,49 +340,211 @@ func (r *DotRender) toData(topo *PSTopo) (*dotGraphData, error) {
 		edge.Attrs["color"] = "red"
 		edges = append(edges, edge)
 	}
-	for _, e := range topo.PidConnSet {
+	for _, e := range topo.ConnEdges() {
 		edge := newDotEdge()
 		edge.From = toDotId(e.From) + ItoDotPort(e.Connection.Laddr.Port)
 		edge.To = toDotId(e.To) + ItoDotPort(e.Connection.Raddr.Port)
 		edge.Attrs["label"] = ""
 		edge.Attrs["color"] = "darkgreen"
 		edge.Attrs["dir"] = "both"
+		weightEdge(edge, topo.Snapshot, e.Connection)
 		edges = append(edges, edge)
 	}
-	for _, e := range topo.IPConnSet {
+	ipNodes := map[string]bool{}
+	for _, e := range topo.IPEdges() {
 		ip := e.Connection.Raddr.IP
 		id := "ip" + replaceIPChar(ip)
+		label := ip + ":" + strconv.Itoa(int(e.Connection.Raddr.Port))
+		if name := topo.Snapshot.HostName(ip); name != "" {
+			label = name + "\n" + label
+		}
+		if !ipNodes[id] {
+			ipNodes[id] = true
+			nodes = append(nodes, &dotNode{
+				ID: id,
+				Attrs: dotAttrs{
+					"label": label,
+					"shape": "box3d",
+				},
+			})
+		}
+
+		edge := newDotEdge()
+		edge.Attrs["label"] = ""
+		edge.Attrs["color"] = "blue"
+		edge.Attrs["dir"] = "both"
+		edge.From = toDotId(e.From) + ItoDotPort(e.Connection.Laddr.Port)
+		edge.To = id
+		weightEdge(edge, topo.Snapshot, e.Connection)
+		edges = append(edges, edge)
+	}
+
+	for _, g := range topo.IPGroups() {
+		id := "grp" + toDotSafeId(g.Name)
 		node := &dotNode{
 			ID: id,
 			Attrs: dotAttrs{
-				"label": ip + ":" + strconv.Itoa(int(e.Connection.Raddr.Port)),
+				"label": fmt.Sprintf("%s\n(%d ip)", g.Name, len(g.IPs)),
 				"shape": "box3d",
 			},
 		}
 		nodes = append(nodes, node)
 
 		edge := newDotEdge()
-		edge.Attrs["label"] = ""
+		edge.Attrs["label"] = g.PortsLabel()
 		edge.Attrs["color"] = "blue"
 		edge.Attrs["dir"] = "both"
-		edge.From = toDotId(e.From) + ItoDotPort(e.Connection.Laddr.Port)
+		edge.From = toDotId(g.From) + StoDotPort("p")
 		edge.To = id
 		edges = append(edges, edge)
 	}
 
-	now := time.Now()
 	return &dotGraphData{
-		Title: fmt.Sprintf("%s (%s)", "PSTopo", now.Format(time.RFC3339)),
-		Nodes: nodes,
-		Edges: edges,
+		Title:    graphTitle("PSTopo", topo.Snapshot.Host),
+		Clusters: clusters,
+		Nodes:    nodes,
+		Edges:    edges,
 	}, nil
 }
 
+// graphTitle is the default title with the hosts, not the render time, so the output is the same over runs
+func graphTitle(name string, hosts ...string) string {
+	var names []string
+	for _, h := range hosts {
+		if h != "" {
+			names = append(names, h)
+		}
+	}
+	if len(names) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, strings.Join(names, ", "))
+}
+
+// Render writes the topo in the format, `dot` by default
+func (r *DotRender) Render(w io.Writer, topo *PSTopo, opts *RenderOptions) error {
+	data, err := r.toData(topo)
+	if err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = &RenderOptions{}
+	}
+	if opts.Title != "" {
+		data.Title = opts.Title
+	}
+	return r.renderData(w, data, opts.Format)
+}
+
+func (r *DotRender) ContentType(format string) string {
+	if format == "" {
+		format = "dot"
+	}
+	return dotFormats[format]
+}
+
+// Write writes the dot file and the png of the topo.
+//
+// Deprecated: use Render with a writer, or RenderFile.
 func (r *DotRender) Write(topo *PSTopo, output string) error {
 	data, err := r.toData(topo)
 	if err != nil {
 		return err
 	}
-	r.writeData(data, output)
-	return nil
+	return r.writeData(data, output)
+}
+
+// prefix makes the ids unique when several graphs are put together
+func (d *dotGraphData) prefix(p string) {
+	var prefixCluster func(c *dotCluster)
+	prefixCluster = func(c *dotCluster) {
+		c.ID = p + c.ID
+		for _, n := range c.Nodes {
+			n.ID = p + n.ID
+		}
+		for _, sub := range c.Clusters {
+			prefixCluster(sub)
+		}
+	}
+	for _, c := range d.Clusters {
+		prefixCluster(c)
+	}
+	for _, n := range d.Nodes {
+		n.ID = p + n.ID
+	}
+	for _, e := range d.Edges {
+		e.From = p + e.From
+		e.To = p + e.To
+	}
+}
+
+func hostPrefix(i int) string {
+	return "h" + strconv.Itoa(i) + "_"
+}
+
+// multiToData puts each host into a cluster, and links the processes across hosts
+func (r *DotRender) multiToData(mt *MultiTopo) (*dotGraphData, error) {
+	var clusters []*dotCluster
+	var edges []*dotEdge
+	for i, h := range mt.Hosts {
+		data, err := r.toData(h.Topo)
+		if err != nil {
+			return nil, err
+		}
+		data.prefix(hostPrefix(i))
+
+		label := h.Name
+		if len(h.Addrs) > 0 {
+			label = fmt.Sprintf("%s (%s)", h.Name, strings.Join(h.Addrs, ", "))
+		}
+		clusters = append(clusters, &dotCluster{
+			ID: "host" + strconv.Itoa(i),
+			Attrs: dotAttrs{
+				"label": label,
+				"style": "solid",
+			},
+			Nodes:    data.Nodes,
+			Clusters: data.Clusters,
+		})
+		edges = append(edges, data.Edges...)
+	}
+
+	for _, e := range mt.Edges() {
+		edge := newDotEdge()
+		edge.From = hostPrefix(e.FromHost) + toDotId(e.From) + ItoDotPort(e.Connection.Laddr.Port)
+		edge.To = hostPrefix(e.ToHost) + toDotId(e.To) + ItoDotPort(e.Connection.Raddr.Port)
+		edge.Attrs["label"] = ""
+		edge.Attrs["color"] = "purple"
+		edge.Attrs["dir"] = "both"
+		edges = append(edges, edge)
+	}
+
+	var names []string
+	for _, h := range mt.Hosts {
+		names = append(names, h.Name)
+	}
+	return &dotGraphData{
+		Title:    graphTitle("PSTopo", names...),
+		Clusters: clusters,
+		Edges:    edges,
+	}, nil
+}
+
+// WriteMulti writes the multi-host topo into output file
+func (r *DotRender) WriteMulti(mt *MultiTopo, output string) error {
+	data, err := r.multiToData(mt)
+	if err != nil {
+		return err
+	}
+	return r.writeData(data, output)
+}
+
+// WriteDot writes the dot source of the topo
+func (r *DotRender) WriteDot(topo *PSTopo, w io.Writer) error {
+	return r.Render(w, topo, &RenderOptions{Format: "dot"})
+}
+
+// WriteImage writes the topo rendered by graphviz, e.g. `svg`, `png`
+func (r *DotRender) WriteImage(topo *PSTopo, format string, w io.Writer) error {
+	return r.Render(w, topo, &RenderOptions{Format: format})
 }
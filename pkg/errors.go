@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DecodeError is an error of decoding a file, e.g. snapshot or config
+type DecodeError struct {
+	Path string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode %s: %v", e.Path, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigError is a key of config against the schema, e.g. unknown, wrong type or out of range
+type ConfigError struct {
+	Line    int
+	Key     string
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Key, e.Message)
+}
+
+// ConfigErrors are all the errors of a config file
+type ConfigErrors struct {
+	Path   string
+	Errors []*ConfigError
+}
+
+func (e *ConfigErrors) Error() string {
+	lines := []string{fmt.Sprintf("invalid config %s", e.Path)}
+	for _, err := range e.Errors {
+		lines = append(lines, fmt.Sprintf("%s:%d: %s", e.Path, err.Line, strings.TrimPrefix(err.Error(), fmt.Sprintf("line %d: ", err.Line))))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// RenderError is an error of rendering at a stage, `template`, `parse`, `render` or `write`
+type RenderError struct {
+	Stage  string
+	Output string
+	Err    error
+}
+
+func (e *RenderError) Error() string {
+	if e.Output == "" {
+		return fmt.Sprintf("render %s: %v", e.Stage, e.Err)
+	}
+	return fmt.Sprintf("render %s %s: %v", e.Stage, e.Output, e.Err)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// ProcessError is an error of inspecting a process, e.g. read its exe or cgroup
+type ProcessError struct {
+	Pid int32
+	Op  string
+	Err error
+}
+
+func (e *ProcessError) Error() string {
+	return fmt.Sprintf("inspect pid %d %s: %v", e.Pid, e.Op, e.Err)
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+// PermissionDenied tells whether the process is not inspected due to permission
+func (e *ProcessError) PermissionDenied() bool {
+	return errors.Is(e.Err, fs.ErrPermission)
+}
+
+// PermissionDenied returns the pids which are not fully inspected due to permission
+func (s *Snapshot) PermissionDenied() []int32 {
+	seen := map[int32]bool{}
+	var pids []int32
+	for _, e := range s.InspectErrors {
+		if e.PermissionDenied() && !seen[e.Pid] {
+			seen[e.Pid] = true
+			pids = append(pids, e.Pid)
+		}
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids
+}
+
+// PermissionSummary describes the processes not inspected due to permission, empty if none
+func (s *Snapshot) PermissionSummary(max int) string {
+	pids := s.PermissionDenied()
+	if len(pids) == 0 {
+		return ""
+	}
+	var names []string
+	for i, pid := range pids {
+		if i >= max {
+			names = append(names, "...")
+			break
+		}
+		name := strconv.Itoa(int(pid))
+		if p, ok := s.PidProcess[pid]; ok && processName(p) != "" && processName(p) != "." {
+			name = fmt.Sprintf("%d (%s)", pid, processName(p))
+		}
+		names = append(names, name)
+	}
+	return fmt.Sprintf("%d processes could not be inspected due to permission: %s",
+		len(pids), strings.Join(names, ", "))
+}
@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestDecodeError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.json")
+	os.WriteFile(path, []byte("{bad"), 0644)
+
+	var decodeErr *DecodeError
+	if _, err := LoadSnapshot(path); !errors.As(err, &decodeErr) || decodeErr.Path != path {
+		t.Errorf("expect decode error of %s, got %v", path, err)
+	}
+	if _, err := LoadConfig(path); !errors.As(err, &decodeErr) {
+		t.Errorf("expect decode error of config, got %v", err)
+	}
+	if _, err := LoadSnapshot(filepath.Join(t.TempDir(), "none.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expect not exist, got %v", err)
+	}
+
+	if err := NewConfig().WriteTo(filepath.Join(path, "config.json")); err == nil {
+		t.Error("expect error of writing config into a file path")
+	}
+	if err := externalSnapshot().DumpFile(filepath.Join(path, "snapshot.json")); err == nil {
+		t.Error("expect error of writing snapshot into a file path")
+	}
+}
+
+func TestPermissionSummary(t *testing.T) {
+	snapshot := externalSnapshot()
+	if summary := snapshot.PermissionSummary(5); summary != "" {
+		t.Errorf("expect no summary, got %q", summary)
+	}
+
+	denied := &fs.PathError{Op: "readlink", Path: "/proc/10/exe", Err: syscall.EACCES}
+	snapshot.InspectErrors = []*ProcessError{
+		{Pid: 10, Op: "exe", Err: denied},
+		{Pid: 10, Op: "cmdline", Err: denied},
+		{Pid: 20, Op: "exe", Err: denied},
+		{Pid: 30, Op: "cgroup", Err: fmt.Errorf("bad cgroup line")},
+	}
+	if pids := snapshot.PermissionDenied(); len(pids) != 2 || pids[0] != 10 || pids[1] != 20 {
+		t.Errorf("got denied pids %v", pids)
+	}
+	summary := snapshot.PermissionSummary(1)
+	if !strings.HasPrefix(summary, "2 processes") || !strings.Contains(summary, "10 (curl), ...") {
+		t.Errorf("bad summary %q", summary)
+	}
+}
@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"fmt"
+	gonet "net"
+	"sort"
+	"strconv"
+)
+
+const (
+	BindLoopback = "loopback"
+	BindPrivate  = "private"
+	BindPublic   = "public"
+	BindAll      = "all"
+	BindAll6     = "all6"
+)
+
+// uid of the system users, nobody included
+const (
+	systemUIDMax = 999
+	nobodyUID    = 65534
+)
+
+// Exposure is a listening socket, with its bind address classified and the owner
+type Exposure struct {
+	Port    uint32 `json:"port"`
+	Addr    string `json:"addr"`
+	Bind    string `json:"bind"`
+	NetNS   uint64 `json:"netns,omitempty"`
+	Pid     int32  `json:"pid"`
+	Process string `json:"process"`
+	Exec    string `json:"exec"`
+	User    string `json:"user"`
+	UID     int32  `json:"uid"`
+	// wildcard bind by a non-system user
+	Highlight bool `json:"highlight"`
+}
+
+// Wildcard tells whether it listens on all interfaces
+func (e *Exposure) Wildcard() bool {
+	return e.Bind == BindAll || e.Bind == BindAll6
+}
+
+// bindClass classifies the bind address
+func bindClass(addr string) string {
+	ip := gonet.ParseIP(addr)
+	switch {
+	case ip == nil:
+		return BindAll
+	case ip.IsUnspecified() && ip.To4() == nil:
+		return BindAll6
+	case ip.IsUnspecified():
+		return BindAll
+	case ip.IsLoopback():
+		return BindLoopback
+	case isPrivateIP(ip):
+		return BindPrivate
+	}
+	return BindPublic
+}
+
+func isSystemUID(uid int32) bool {
+	return uid >= 0 && uid <= systemUIDMax || uid == nobodyUID
+}
+
+// Exposures lists every listening socket of all network namespaces
+func (s *Snapshot) Exposures() []*Exposure {
+	var list []*Exposure
+	add := func(ns uint64, idx *PortIndex) {
+		for port, conns := range idx.ListenPortConnections {
+			for _, conn := range conns {
+				e := &Exposure{
+					Port:  port,
+					Addr:  conn.Laddr.IP,
+					Bind:  bindClass(conn.Laddr.IP),
+					NetNS: ns,
+					Pid:   conn.Pid,
+					UID:   -1,
+				}
+				if p, ok := s.PidProcess[conn.Pid]; ok {
+					e.Process, e.Exec, e.User, e.UID = processName(p), p.Exec, p.User, p.UID
+				}
+				e.Highlight = e.Wildcard() && e.UID >= 0 && !isSystemUID(e.UID)
+				list = append(list, e)
+			}
+		}
+	}
+	add(0, &s.PortIndex)
+	for ns, idx := range s.Namespaces {
+		add(ns, idx)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.NetNS != b.NetNS {
+			return a.NetNS < b.NetNS
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		if a.Addr != b.Addr {
+			return a.Addr < b.Addr
+		}
+		return a.Pid < b.Pid
+	})
+	return list
+}
+
+// exposureToData links the outside world to the listeners not bound on loopback
+func (r *DotRender) exposureToData(list []*Exposure) *dotGraphData {
+	outside := &dotNode{
+		ID:    "outside",
+		Label: "outside world",
+		Attrs: dotAttrs{"shape": "doublecircle", "color": "red"},
+	}
+	nodes := []*dotNode{outside}
+	var edges []*dotEdge
+
+	processNodes := map[string]*dotNode{}
+	for _, e := range list {
+		id := toDotId(e.Pid)
+		if e.NetNS != 0 {
+			id = fmt.Sprintf("ns%d_%s", e.NetNS, id)
+		}
+		node, ok := processNodes[id]
+		if !ok {
+			label := fmt.Sprintf("%s | pid %d | user %s", e.Process, e.Pid, e.User)
+			node = &dotNode{ID: id, Label: label, Attrs: dotAttrs{"shape": "record"}}
+			processNodes[id] = node
+			nodes = append(nodes, node)
+		}
+		if e.Highlight {
+			node.Attrs["color"] = "red"
+		}
+		if e.Bind == BindLoopback {
+			continue
+		}
+
+		edge := newDotEdge()
+		edge.From = outside.ID
+		edge.To = id
+		edge.Attrs["label"] = gonet.JoinHostPort(e.Addr, strconv.Itoa(int(e.Port)))
+		switch {
+		case e.Highlight:
+			edge.Attrs["color"] = "red"
+			edge.Attrs["penwidth"] = "3"
+		case e.Bind == BindPrivate:
+			edge.Attrs["style"] = "dashed"
+		}
+		edges = append(edges, edge)
+	}
+
+	return &dotGraphData{
+		Title: graphTitle("PSTopo exposure"),
+		Nodes: nodes,
+		Edges: edges,
+	}
+}
+
+// WriteExposure writes the exposure graph into output file
+func (r *DotRender) WriteExposure(list []*Exposure, output string) error {
+	return r.writeData(r.exposureToData(list), output)
+}
@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func TestExposures(t *testing.T) {
+	snapshot := NewSnapshot()
+	snapshot.PidProcess[1] = &Process{Pid: 1, Name: "sshd", Exec: "/usr/sbin/sshd", User: "root", UID: 0}
+	snapshot.PidProcess[2] = &Process{Pid: 2, Name: "devserver", Exec: "/home/me/devserver", User: "me", UID: 1000}
+	for pid := range snapshot.PidProcess {
+		snapshot.PidPort[pid] = NewPortSet()
+		snapshot.PidListenPort[pid] = NewPortSet()
+	}
+	listens := []net.ConnectionStat{
+		{Pid: 1, Laddr: net.Addr{IP: "0.0.0.0", Port: 22}},
+		{Pid: 1, Laddr: net.Addr{IP: "::", Port: 22}},
+		{Pid: 2, Laddr: net.Addr{IP: "::", Port: 3000}},
+		{Pid: 2, Laddr: net.Addr{IP: "127.0.0.1", Port: 3001}},
+		{Pid: 2, Laddr: net.Addr{IP: "10.0.0.5", Port: 3002}},
+	}
+	for _, conn := range listens {
+		conn.Status = "LISTEN"
+		snapshot.addConnection(&snapshot.PortIndex, conn)
+	}
+
+	list := snapshot.Exposures()
+	want := []struct {
+		port      uint32
+		bind      string
+		highlight bool
+	}{
+		{22, BindAll, false},
+		{22, BindAll6, false},
+		{3000, BindAll6, true},
+		{3001, BindLoopback, false},
+		{3002, BindPrivate, false},
+	}
+	if len(list) != len(want) {
+		t.Fatalf("got %d exposures, want %d", len(list), len(want))
+	}
+	for i, w := range want {
+		e := list[i]
+		if e.Port != w.port || e.Bind != w.bind || e.Highlight != w.highlight {
+			t.Errorf("got %d %s %v, want %+v", e.Port, e.Bind, e.Highlight, w)
+		}
+	}
+
+	data := (&DotRender{}).exposureToData(list)
+	buf, err := executeTemplate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the loopback one is not linked to outside
+	if n := strings.Count(buf.String(), "outside -> "); n != 4 {
+		t.Errorf("got %d exposed edges, want 4", n)
+	}
+}
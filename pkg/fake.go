@@ -0,0 +1,365 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	gonet "net"
+	"os"
+	"strconv"
+
+	"github.com/shirou/gopsutil/v3/net"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	fakeHostNetNS    = 4026531993
+	fakeFirstNetNS   = 4026532200 // of the other namespaces
+	fakeMinPid       = 300        // the kernel skips the low pids on wrap
+	fakeEphemeral    = 32768
+	fakeEphemeralMax = 61000
+)
+
+// FakeSpec describes a synthetic host, see GenerateSnapshot
+type FakeSpec struct {
+	Host string `yaml:"host" json:"host"`
+	// address of the host, `10.0.0.1` by default
+	Addr string `yaml:"addr" json:"addr"`
+	// seed of the random ephemeral ports and pid gaps, the same spec and seed give the same snapshot
+	Seed int64 `yaml:"seed" json:"seed"`
+
+	// pids are allocated from PidStart with random gaps (other short-lived processes),
+	// and wrap at PidMax like the kernel, so a small PidMax reuses the low pids
+	PidStart int32 `yaml:"pid_start" json:"pid_start"`
+	PidMax   int32 `yaml:"pid_max" json:"pid_max"`
+
+	Services []*FakeService `yaml:"services" json:"services"`
+}
+
+// FakeService is a master process with workers, e.g. nginx or postgres
+type FakeService struct {
+	Name    string   `yaml:"name" json:"name"`
+	User    string   `yaml:"user" json:"user"`
+	Workers int      `yaml:"workers" json:"workers"`
+	Listen  []uint32 `yaml:"listen" json:"listen"`
+	// listen address, `0.0.0.0` by default
+	Bind string `yaml:"bind" json:"bind"`
+	// dependencies, the name of a service (its first listen port), or an external `host:port`
+	Calls []string `yaml:"calls" json:"calls"`
+	// connections to each dependency, 1 by default
+	Connections int `yaml:"connections" json:"connections"`
+	// network namespace, e.g. of a container, shared by the services of the same name,
+	// the host one by default. The dependencies are in the same namespace or external.
+	Namespace string `yaml:"namespace" json:"namespace"`
+}
+
+// DefaultFakeSpec is a small web stack for demo
+func DefaultFakeSpec() *FakeSpec {
+	return &FakeSpec{
+		Host: "demo",
+		Seed: 1,
+		Services: []*FakeService{
+			{Name: "nginx", User: "www-data", Workers: 2, Listen: []uint32{80, 443}, Calls: []string{"api"}, Connections: 2},
+			{Name: "api", User: "app", Workers: 4, Listen: []uint32{8080}, Bind: "127.0.0.1",
+				Calls: []string{"postgres", "redis", "api.stripe.com:443"}, Connections: 2},
+			{Name: "jobs", User: "app", Workers: 2, Calls: []string{"redis", "postgres", "smtp.example.com:587"}},
+			{Name: "postgres", User: "postgres", Workers: 3, Listen: []uint32{5432}, Bind: "127.0.0.1"},
+			{Name: "redis", User: "redis", Listen: []uint32{6379}, Bind: "127.0.0.1"},
+		},
+	}
+}
+
+// LoadFakeSpec reads a spec in yaml (or json)
+func LoadFakeSpec(path string) (*FakeSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// unknown keys are errors, a typo like `worker` would be ignored
+	spec := &FakeSpec{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(spec); err != nil && err != io.EOF {
+		return nil, &DecodeError{Path: path, Err: err}
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// Validate checks the names and the dependencies, and fills the defaults
+func (spec *FakeSpec) Validate() error {
+	if spec.Host == "" {
+		spec.Host = "fake"
+	}
+	if spec.Addr == "" {
+		spec.Addr = "10.0.0.1"
+	}
+	if spec.PidMax <= 0 {
+		spec.PidMax = 32768
+	}
+	if spec.PidStart <= 0 {
+		spec.PidStart = 1000
+	}
+	if spec.PidMax <= fakeMinPid || spec.PidStart >= spec.PidMax {
+		return fmt.Errorf("bad pid range %d of max %d", spec.PidStart, spec.PidMax)
+	}
+
+	names := map[string]*FakeService{}
+	for i, s := range spec.Services {
+		if s.Name == "" {
+			return fmt.Errorf("service %d: no name", i+1)
+		}
+		if _, ok := names[s.Name]; ok {
+			return fmt.Errorf("service %s: duplicated", s.Name)
+		}
+		names[s.Name] = s
+		if s.User == "" {
+			s.User = "root"
+		}
+		if s.Bind == "" {
+			s.Bind = "0.0.0.0"
+		}
+		if s.Connections <= 0 {
+			s.Connections = 1
+		}
+		if s.Workers < 0 {
+			return fmt.Errorf("service %s: negative workers %d", s.Name, s.Workers)
+		}
+	}
+
+	// pids and ephemeral ports (of each namespace) are allocated by retry, so they have to be enough
+	processes := 0
+	connections := map[string]int{}
+	listens := map[string]map[uint32]string{}
+	for _, s := range spec.Services {
+		processes += 1 + s.Workers
+		connections[s.Namespace] += len(s.Calls) * s.Connections
+		if listens[s.Namespace] == nil {
+			listens[s.Namespace] = map[uint32]string{}
+		}
+		for _, port := range s.Listen {
+			if other, ok := listens[s.Namespace][port]; ok {
+				return fmt.Errorf("service %s: port %d is listened by %s", s.Name, port, other)
+			}
+			listens[s.Namespace][port] = s.Name
+		}
+	}
+	if pids := int(spec.PidMax - fakeMinPid); processes > pids {
+		return fmt.Errorf("%d processes are more than %d pids of %d..%d", processes, pids, fakeMinPid, spec.PidMax)
+	}
+	for ns, n := range connections {
+		ports := fakeEphemeralMax - fakeEphemeral
+		for port := range listens[ns] {
+			if port >= fakeEphemeral && port < fakeEphemeralMax {
+				ports--
+			}
+		}
+		if n > ports {
+			return fmt.Errorf("%d connections are more than %d ephemeral ports", n, ports)
+		}
+	}
+
+	for _, s := range spec.Services {
+		for _, call := range s.Calls {
+			if callee, ok := names[call]; ok {
+				if len(callee.Listen) == 0 {
+					return fmt.Errorf("service %s: %s listens nothing", s.Name, call)
+				}
+				if callee.Namespace != s.Namespace {
+					return fmt.Errorf("service %s: %s is in another namespace", s.Name, call)
+				}
+				continue
+			}
+			if _, _, err := gonet.SplitHostPort(call); err != nil {
+				return fmt.Errorf("service %s: unknown service or bad endpoint %q", s.Name, call)
+			}
+		}
+	}
+	return nil
+}
+
+// fakeHost allocates the pids and ports while generating
+type fakeHost struct {
+	spec     *FakeSpec
+	rand     *rand.Rand
+	snapshot *Snapshot
+	next     int32
+	// the network namespaces by name, and the ephemeral ports used in each
+	netns     map[string]uint64
+	ephemeral map[uint64]map[uint32]bool
+}
+
+func (h *fakeHost) allocPid() int32 {
+	for {
+		pid := h.next
+		h.next += 1 + int32(h.rand.Intn(8))
+		if h.next >= h.spec.PidMax {
+			h.next = fakeMinPid + h.next - h.spec.PidMax
+		}
+		if _, ok := h.snapshot.PidProcess[pid]; !ok && pid >= fakeMinPid {
+			return pid
+		}
+	}
+}
+
+func (h *fakeHost) allocPort(netns uint64) uint32 {
+	for {
+		port := uint32(fakeEphemeral + h.rand.Intn(fakeEphemeralMax-fakeEphemeral))
+		if !h.ephemeral[netns][port] {
+			h.ephemeral[netns][port] = true
+			return port
+		}
+	}
+}
+
+// namespace returns the inode of the namespace, a new one is after the others in order of the spec
+func (h *fakeHost) namespace(name string) uint64 {
+	if netns, ok := h.netns[name]; ok {
+		return netns
+	}
+	netns := uint64(fakeFirstNetNS + len(h.netns) - 1)
+	h.netns[name] = netns
+	h.ephemeral[netns] = map[uint32]bool{}
+	h.snapshot.Namespaces[netns] = NewPortIndex()
+	return netns
+}
+
+func (h *fakeHost) addProcess(p *Process) {
+	s := h.snapshot
+	if p.NetNS == 0 {
+		p.NetNS = fakeHostNetNS
+	}
+	p.UID = fakeUID(p.User)
+	if p.Children == nil {
+		p.Children = []int32{}
+	}
+	s.PidProcess[p.Pid] = p
+	s.PidPort[p.Pid] = NewPortSet()
+	s.PidListenPort[p.Pid] = NewPortSet()
+	if parent, ok := s.PidProcess[p.Parent]; ok && p.Parent != p.Pid {
+		parent.Children = append(parent.Children, p.Pid)
+	}
+}
+
+// fakeUID is a stable uid of the user, 0 for root
+func fakeUID(user string) int32 {
+	if user == "root" {
+		return 0
+	}
+	return 1000 + int32(hashOf(user)%1000)
+}
+
+// fakeIP is a stable address in TEST-NET-3 of the external host
+func fakeIP(host string) string {
+	if ip := gonet.ParseIP(host); ip != nil {
+		return host
+	}
+	return "203.0.113." + strconv.Itoa(int(1+hashOf(host)%254))
+}
+
+func hashOf(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// GenerateSnapshot generates the snapshot of the spec, without root or a live host.
+// Every service is a master process (listening) under init with its workers, and
+// each dependency is a number of established connections from the workers (or the master)
+// to the callee by loopback, or to the external endpoint from the host address.
+func GenerateSnapshot(spec *FakeSpec) (*Snapshot, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	h := &fakeHost{
+		spec:      spec,
+		rand:      rand.New(rand.NewSource(spec.Seed)),
+		snapshot:  NewSnapshot(),
+		next:      spec.PidStart,
+		netns:     map[string]uint64{"": fakeHostNetNS},
+		ephemeral: map[uint64]map[uint32]bool{fakeHostNetNS: {}},
+	}
+	for _, svc := range spec.Services {
+		netns := h.namespace(svc.Namespace)
+		for _, port := range svc.Listen {
+			h.ephemeral[netns][port] = true
+		}
+	}
+	s := h.snapshot
+	s.Host = spec.Host
+	s.Addrs = []string{spec.Addr}
+	s.HostNetNS = fakeHostNetNS
+
+	h.addProcess(&Process{Pid: 1, Name: "systemd", Exec: "/usr/lib/systemd/systemd", Cmdline: "/sbin/init", User: "root", Unit: "init.scope"})
+
+	// the processes serving the connections of each service, the workers or the master
+	members := map[string][]int32{}
+	for _, svc := range spec.Services {
+		exec := "/usr/bin/" + svc.Name
+		netns := h.namespace(svc.Namespace)
+		idx := s.Index(netns)
+		master := &Process{Pid: h.allocPid(), Name: svc.Name, Exec: exec, Cmdline: exec, Parent: 1, User: svc.User,
+			Unit: svc.Name + ".service", NetNS: netns}
+		for _, port := range svc.Listen {
+			master.Cmdline += " --listen " + gonet.JoinHostPort(svc.Bind, strconv.Itoa(int(port)))
+		}
+		h.addProcess(master)
+		for _, port := range svc.Listen {
+			s.addConnection(idx, net.ConnectionStat{Pid: master.Pid, Status: "LISTEN",
+				Family: 2, Type: 1, Laddr: net.Addr{IP: svc.Bind, Port: port}})
+		}
+
+		for i := 0; i < svc.Workers; i++ {
+			worker := &Process{Pid: h.allocPid(), Name: svc.Name, Exec: exec, Cmdline: svc.Name + ": worker " + strconv.Itoa(i),
+				Parent: master.Pid, User: svc.User, Unit: master.Unit, NetNS: netns}
+			h.addProcess(worker)
+			members[svc.Name] = append(members[svc.Name], worker.Pid)
+		}
+		if svc.Workers == 0 {
+			members[svc.Name] = []int32{master.Pid}
+		}
+	}
+
+	services := map[string]*FakeService{}
+	for _, svc := range spec.Services {
+		services[svc.Name] = svc
+	}
+	for _, svc := range spec.Services {
+		clients := members[svc.Name]
+		netns := h.namespace(svc.Namespace)
+		idx := s.Index(netns)
+		for _, call := range svc.Calls {
+			for c := 0; c < svc.Connections; c++ {
+				client := clients[h.rand.Intn(len(clients))]
+				local := net.Addr{IP: spec.Addr, Port: h.allocPort(netns)}
+
+				callee, ok := services[call]
+				if !ok {
+					host, port, _ := gonet.SplitHostPort(call)
+					remote, _ := strconv.Atoi(port)
+					ip := fakeIP(host)
+					if ip != host {
+						s.Hostnames[ip] = host
+					}
+					s.addConnection(idx, net.ConnectionStat{Pid: client, Status: "ESTABLISHED",
+						Family: 2, Type: 1, Laddr: local, Raddr: net.Addr{IP: ip, Port: uint32(remote)}})
+					continue
+				}
+
+				servers := members[call]
+				server := servers[h.rand.Intn(len(servers))]
+				local.IP = "127.0.0.1"
+				remote := net.Addr{IP: "127.0.0.1", Port: callee.Listen[0]}
+				s.addConnection(idx, net.ConnectionStat{Pid: client, Status: "ESTABLISHED",
+					Family: 2, Type: 1, Laddr: local, Raddr: remote})
+				s.addConnection(idx, net.ConnectionStat{Pid: server, Status: "ESTABLISHED",
+					Family: 2, Type: 1, Laddr: remote, Raddr: local})
+			}
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateSnapshot(t *testing.T) {
+	snapshot, err := GenerateSnapshot(DefaultFakeSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// init, and the masters with workers
+	if n := len(snapshot.PidProcess); n != 1+3+5+3+4+1 {
+		t.Errorf("expect 17 processes, got %d", n)
+	}
+	again, _ := GenerateSnapshot(DefaultFakeSpec())
+	if !bytes.Equal(dumpSnapshot(t, snapshot), dumpSnapshot(t, again)) {
+		t.Error("expect the same snapshot of the same spec")
+	}
+
+	topo := NewTopo(snapshot).Analyse(&Config{Cmd: []string{"/usr/bin/nginx", "/usr/bin/api"}})
+	names := map[string]bool{}
+	for _, p := range topo.Processes() {
+		names[p.Name] = true
+	}
+	for _, name := range []string{"systemd", "api", "nginx", "postgres", "redis"} {
+		if !names[name] {
+			t.Errorf("expect %s in topo, got %v", name, names)
+		}
+	}
+	external := false
+	for _, e := range topo.IPEdges() {
+		if snapshot.HostName(e.Connection.Raddr.IP) == "api.stripe.com" {
+			external = true
+		}
+	}
+	if !external {
+		t.Error("expect the connection to api.stripe.com")
+	}
+}
+
+func TestGenerateSnapshotPidReuse(t *testing.T) {
+	spec := DefaultFakeSpec()
+	spec.PidStart, spec.PidMax = 32740, 32768
+	snapshot, err := GenerateSnapshot(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped := false
+	for _, p := range snapshot.Processes() {
+		if p.Pid != 1 && (p.Pid < fakeMinPid || p.Pid >= spec.PidMax) {
+			t.Errorf("pid %d out of range", p.Pid)
+		}
+		if p.Parent > p.Pid {
+			wrapped = true
+		}
+	}
+	if !wrapped {
+		t.Error("expect a child of lower pid than the parent after wrap")
+	}
+}
+
+func TestLoadFakeSpec(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "spec.yaml")
+	os.WriteFile(path, []byte(`
+host: test
+services:
+  - name: web
+    listen: [80]
+    calls: [db, "example.com:443"]
+  - name: db
+    listen: [5432]
+`), 0644)
+	spec, err := LoadFakeSpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Services[0].Bind != "0.0.0.0" || spec.Services[1].Connections != 1 {
+		t.Errorf("expect defaults filled, got %+v", spec.Services[0])
+	}
+
+	os.WriteFile(path, []byte("services:\n  - name: web\n    calls: [db]\n"), 0644)
+	if _, err := LoadFakeSpec(path); err == nil || !strings.Contains(err.Error(), `"db"`) {
+		t.Errorf("expect unknown service error, got %v", err)
+	}
+
+	// more than the pids or the ephemeral ports, rather than hang in allocating
+	for _, text := range []string{
+		"services:\n  - name: web\n    workers: 40000\n",
+		"services:\n  - name: web\n    calls: [db]\n    connections: 30000\n  - name: db\n    listen: [5432]\n",
+	} {
+		os.WriteFile(path, []byte(text), 0644)
+		if _, err := LoadFakeSpec(path); err == nil || !strings.Contains(err.Error(), "more than") {
+			t.Errorf("expect exhausted error, got %v", err)
+		}
+	}
+
+	for text, want := range map[string]string{
+		"services:\n  - name: web\n    worker: 4\n":                                                        "field worker not found",
+		"services:\n  - name: web\n    workers: -1\n":                                                      "negative workers",
+		"services:\n  - name: web\n    listen: [80]\n  - name: api\n    listen: [8080, 80]\n":              "port 80 is listened by web",
+		"services:\n  - name: web\n    calls: [db]\n  - name: db\n    listen: [5432]\n    namespace: db\n": "another namespace",
+	} {
+		os.WriteFile(path, []byte(text), 0644)
+		if _, err := LoadFakeSpec(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expect %q error, got %v", want, err)
+		}
+	}
+}
+
+func TestGenerateSnapshotNamespace(t *testing.T) {
+	// the same port in a container is not a duplicate
+	spec := &FakeSpec{Services: []*FakeService{
+		{Name: "web", Listen: []uint32{80}, Calls: []string{"api"}, Connections: 3},
+		{Name: "api", Listen: []uint32{8080}},
+		{Name: "app", Listen: []uint32{80}, Calls: []string{"cache"}, Namespace: "app"},
+		{Name: "cache", Listen: []uint32{6379}, Namespace: "app"},
+	}}
+	snapshot, err := GenerateSnapshot(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, ok := snapshot.Namespaces[fakeFirstNetNS]
+	if !ok || len(snapshot.Namespaces) != 1 {
+		t.Fatalf("expect the namespace of app, got %v", snapshot.Namespaces)
+	}
+	if idx.ListenPortPid[80] == snapshot.ListenPortPid[80] || len(idx.PortConnection) != 2 || len(snapshot.sockets()) != 6 {
+		t.Errorf("bad sockets of the namespaces %+v", idx)
+	}
+	topo := NewTopo(snapshot).Analyse(&Config{Cmd: []string{"/usr/bin/app"}})
+	if _, ok := topo.PidSet[idx.ListenPortPid[6379]]; !ok {
+		t.Error("expect cache in the topo of app")
+	}
+}
@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkGolden compares the output with the golden file, or updates it with `-update`
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *updateGolden {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file, run with -update to create: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from %s, run with -update if expected\n%s", name, path, got)
+	}
+}
+
+func loadFixture(t *testing.T) *Snapshot {
+	t.Helper()
+	snapshot, err := LoadSnapshot(filepath.Join("testdata", "fixture.snapshot.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+func TestGoldenRender(t *testing.T) {
+	cases := []struct {
+		name   string
+		render string
+		cfg    *Config
+		title  string
+	}{
+		{"topo_app.dot", "dot", &Config{Cmd: []string{"app"}}, "fixture"},
+		{"topo_nginx_group.dot", "dot", &Config{Cmd: []string{"nginx"}, Group: true}, "fixture"},
+		{"topo_all.dot", "dot", &Config{All: true}, "fixture"},
+		{"topo_all.json", "json", &Config{All: true}, "fixture"},
+		// the default title, as the cli renders
+		{"topo_app_default_title.dot", "dot", &Config{Cmd: []string{"app"}}, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			render, err := NewRender(c.render)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// maps are iterated in random order, so render a few times
+			var first []byte
+			for i := 0; i < 5; i++ {
+				topo := NewTopo(loadFixture(t)).Analyse(c.cfg)
+				var buf bytes.Buffer
+				if err := render.Render(&buf, topo, &RenderOptions{Format: c.render, Title: c.title}); err != nil {
+					t.Fatal(err)
+				}
+				if i == 0 {
+					first = buf.Bytes()
+				} else if !bytes.Equal(first, buf.Bytes()) {
+					t.Fatalf("output differs between runs\n%s\n%s", first, buf.Bytes())
+				}
+			}
+			checkGolden(t, c.name, first)
+		})
+	}
+}
+
+func TestGoldenSnapshot(t *testing.T) {
+	snapshot := loadFixture(t)
+	data := dumpSnapshot(t, snapshot)
+	for i := 0; i < 5; i++ {
+		if again := dumpSnapshot(t, loadFixture(t)); !bytes.Equal(data, again) {
+			t.Fatal("snapshot dump differs between runs")
+		}
+	}
+	checkGolden(t, "snapshot.json", data)
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg := &Config{Cmd: []string{"app"}, Port: []uint32{5432}, Group: true,
+		Networks: map[string]string{"10.0.0.0/8": "internal", "93.184.216.0/24": "example", "8.8.8.0/24": "google"}}
+	if err := cfg.WriteTo(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "config.json", data)
+}
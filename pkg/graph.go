@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"sort"
+	"strconv"
+)
+
+const (
+	NodeProcess = "process"
+	NodeIP      = "ip"
+	NodeGroup   = "group"
+
+	EdgeChild      = "child"
+	EdgeConnection = "connection"
+	EdgeIP         = "ip"
+	EdgeGroup      = "group"
+)
+
+// JSONGraph is the plain json form of the topo, for api and other tools
+type JSONGraph struct {
+	Nodes []*JSONNode `json:"nodes"`
+	Edges []*JSONEdge `json:"edges"`
+}
+
+type JSONNode struct {
+	ID          string   `json:"id"`
+	Kind        string   `json:"kind"`
+	Label       string   `json:"label"`
+	Pid         int32    `json:"pid,omitempty"`
+	Cmdline     string   `json:"cmdline,omitempty"`
+	Ports       []uint32 `json:"ports,omitempty"`
+	ListenPorts []uint32 `json:"listen_ports,omitempty"`
+}
+
+type JSONEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Kind  string `json:"kind"`
+	Label string `json:"label,omitempty"`
+}
+
+func sortedPorts(set *PortSet) []uint32 {
+	return append([]uint32(nil), set.Ports()...)
+}
+
+// JSONGraph converts the topo into nodes and edges
+func (tp *PSTopo) JSONGraph() *JSONGraph {
+	g := &JSONGraph{
+		Nodes: []*JSONNode{},
+		Edges: []*JSONEdge{},
+	}
+	nodes := map[string]*JSONNode{}
+	addNode := func(n *JSONNode) {
+		if _, ok := nodes[n.ID]; !ok {
+			nodes[n.ID] = n
+			g.Nodes = append(g.Nodes, n)
+		}
+	}
+
+	for _, p := range tp.Processes() {
+		pid := p.Pid
+		addNode(&JSONNode{
+			ID:          toDotId(pid),
+			Kind:        NodeProcess,
+			Label:       p.Name,
+			Pid:         pid,
+			Cmdline:     p.Cmdline,
+			Ports:       sortedPorts(tp.Snapshot.PidPort[pid]),
+			ListenPorts: sortedPorts(tp.Snapshot.PidListenPort[pid]),
+		})
+	}
+	for _, e := range tp.ChildEdges() {
+		g.Edges = append(g.Edges, &JSONEdge{From: toDotId(e.From), To: toDotId(e.To), Kind: EdgeChild})
+	}
+	for _, e := range tp.ConnEdges() {
+		g.Edges = append(g.Edges, &JSONEdge{
+			From:  toDotId(e.From),
+			To:    toDotId(e.To),
+			Kind:  EdgeConnection,
+			Label: strconv.Itoa(int(e.Connection.Laddr.Port)) + "->" + strconv.Itoa(int(e.Connection.Raddr.Port)),
+		})
+	}
+	for _, e := range tp.IPEdges() {
+		ip := e.Connection.Raddr.IP
+		id := "ip" + replaceIPChar(ip)
+		label := ip
+		if name := tp.Snapshot.HostName(ip); name != "" {
+			label = name
+		}
+		addNode(&JSONNode{ID: id, Kind: NodeIP, Label: label})
+		g.Edges = append(g.Edges, &JSONEdge{
+			From:  toDotId(e.From),
+			To:    id,
+			Kind:  EdgeIP,
+			Label: strconv.Itoa(int(e.Connection.Raddr.Port)),
+		})
+	}
+	for _, group := range tp.IPGroups() {
+		id := "grp" + toDotSafeId(group.Name)
+		addNode(&JSONNode{ID: id, Kind: NodeGroup, Label: group.Name})
+		g.Edges = append(g.Edges, &JSONEdge{From: toDotId(group.From), To: id, Kind: EdgeGroup, Label: group.PortsLabel()})
+	}
+
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Label < b.Label
+	})
+	return g
+}
@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"fmt"
+	gonet "net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultGroupPrefix  = 24
+	defaultGroupPrefix6 = 64
+)
+
+// IPGroup summarises the connections from a process to a group of external ip,
+// e.g. a named network, a cidr block, or the fallback /24
+type IPGroup struct {
+	Name  string
+	From  int32
+	IPs   map[string]bool
+	Ports map[uint32]int // remote port to connection count
+}
+
+func (g *IPGroup) Count() int {
+	count := 0
+	for _, c := range g.Ports {
+		count += c
+	}
+	return count
+}
+
+// PortsLabel lists ports with the connection count, e.g. `443 x12, 80 x3`
+func (g *IPGroup) PortsLabel() string {
+	var ports []uint32
+	for port := range g.Ports {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	var parts []string
+	for _, port := range ports {
+		parts = append(parts, fmt.Sprintf("%d x%d", port, g.Ports[port]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// groupNetworks parses the configured networks of cidr to name
+func groupNetworks(cfg *Config) ([]namedNetwork, error) {
+	var networks []namedNetwork
+	for key, name := range cfg.Networks {
+		n, err := newNamedNetwork(key, name)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// groupName returns the group of an ip, using (in order)
+// the configured networks, the recorded name, and the fallback prefix block.
+func (tp *PSTopo) groupName(networks []namedNetwork, prefix int, prefix6 int, addr string) string {
+	ip := gonet.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+	if name := matchNetwork(networks, ip); name != "" {
+		return name
+	}
+	if name := tp.Snapshot.HostName(addr); name != "" {
+		return name
+	}
+
+	bits, total := prefix, 32
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	} else {
+		bits, total = prefix6, 128
+	}
+	return ip.Mask(gonet.CIDRMask(bits, total)).String() + "/" + strconv.Itoa(bits)
+}
+
+// groupIP replaces the one edge per ip:port with the edge per group
+func (tp *PSTopo) groupIP(cfg *Config) error {
+	networks, err := groupNetworks(cfg)
+	if err != nil {
+		return err
+	}
+	prefix := cfg.GroupPrefix
+	if prefix <= 0 {
+		prefix = defaultGroupPrefix
+	}
+	prefix6 := cfg.GroupPrefix6
+	if prefix6 <= 0 {
+		prefix6 = defaultGroupPrefix6
+	}
+	if prefix > 32 || prefix6 > 128 {
+		return fmt.Errorf("group prefix /%d or /%d is out of range of ipv4 or ipv6", prefix, prefix6)
+	}
+
+	for key, e := range tp.IPConnSet {
+		name := tp.groupName(networks, prefix, prefix6, e.Connection.Raddr.IP)
+		groupKey := strconv.Itoa(int(e.From)) + "->" + name
+		group, ok := tp.IPGroupSet[groupKey]
+		if !ok {
+			group = &IPGroup{
+				Name:  name,
+				From:  e.From,
+				IPs:   map[string]bool{},
+				Ports: map[uint32]int{},
+			}
+			tp.IPGroupSet[groupKey] = group
+		}
+		group.IPs[e.Connection.Raddr.IP] = true
+		group.Ports[e.Connection.Raddr.Port]++
+
+		delete(tp.IPConnSet, key)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func externalSnapshot() *Snapshot {
+	snapshot := NewSnapshot()
+	snapshot.PidProcess[10] = &Process{Pid: 10, Name: "curl", Exec: "/usr/bin/curl", Cmdline: "curl cdn"}
+	snapshot.PidPort[10] = NewPortSet()
+	snapshot.PidListenPort[10] = NewPortSet()
+
+	remotes := []net.Addr{
+		{IP: "93.184.216.1", Port: 443},
+		{IP: "93.184.216.2", Port: 443},
+		{IP: "93.184.216.2", Port: 80},
+		{IP: "3.1.2.3", Port: 443},
+		{IP: "8.8.8.8", Port: 53},
+	}
+	for i, raddr := range remotes {
+		port := uint32(40000 + i)
+		snapshot.PidPort[10].Add(port)
+		snapshot.PortPid[port] = 10
+		snapshot.PortConnection[port] = net.ConnectionStat{
+			Pid:   10,
+			Laddr: net.Addr{IP: "192.168.1.2", Port: port},
+			Raddr: raddr,
+		}
+	}
+	snapshot.Hostnames["8.8.8.8"] = "dns.google"
+	return snapshot
+}
+
+func TestGroupIP(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Cmd = []string{"curl"}
+	cfg.Group = true
+	cfg.Networks = map[string]string{"3.0.0.0/9": "AWS us-east-1"}
+
+	topo := NewTopo(externalSnapshot()).Analyse(cfg)
+	if len(topo.IPConnSet) != 0 {
+		t.Errorf("IPConnSet should be grouped, left %d", len(topo.IPConnSet))
+	}
+
+	cases := map[string]string{
+		"10->93.184.216.0/24": "80 x1, 443 x2",
+		"10->AWS us-east-1":   "443 x1",
+		"10->dns.google":      "53 x1",
+	}
+	if len(topo.IPGroupSet) != len(cases) {
+		t.Fatalf("got %d groups, want %d", len(topo.IPGroupSet), len(cases))
+	}
+	for key, label := range cases {
+		group, ok := topo.IPGroupSet[key]
+		if !ok {
+			t.Errorf("no group %s", key)
+			continue
+		}
+		if got := group.PortsLabel(); got != label {
+			t.Errorf("group %s label = %q, want %q", key, got, label)
+		}
+	}
+	if n := len(topo.IPGroupSet["10->93.184.216.0/24"].IPs); n != 2 {
+		t.Errorf("got %d ip in /24 group, want 2", n)
+	}
+}
+
+func TestGroupIPv6(t *testing.T) {
+	snapshot := externalSnapshot()
+	for i, ip := range []string{"2606:4700:10::1", "2606:4700:20::1"} {
+		port := uint32(41000 + i)
+		snapshot.PidPort[10].Add(port)
+		snapshot.PortPid[port] = 10
+		snapshot.PortConnection[port] = net.ConnectionStat{
+			Pid:   10,
+			Laddr: net.Addr{IP: "2001:db8::2", Port: port},
+			Raddr: net.Addr{IP: ip, Port: 443},
+		}
+	}
+
+	cfg := NewConfig()
+	cfg.Cmd = []string{"curl"}
+	cfg.Group = true
+	topo := NewTopo(snapshot).Analyse(cfg)
+	for _, key := range []string{"10->2606:4700:10::/64", "10->2606:4700:20::/64"} {
+		if _, ok := topo.IPGroupSet[key]; !ok {
+			t.Errorf("no group %s by default", key)
+		}
+	}
+
+	cfg.GroupPrefix6 = 32
+	topo = NewTopo(snapshot).Analyse(cfg)
+	if group, ok := topo.IPGroupSet["10->2606:4700::/32"]; !ok || len(group.IPs) != 2 {
+		t.Errorf("ipv6 is not grouped by /32: %v", topo.IPGroupSet)
+	}
+	if _, ok := topo.IPGroupSet["10->93.184.216.0/24"]; !ok {
+		t.Error("ipv4 prefix is changed by the ipv6 one")
+	}
+}
+
+func TestGroupIPPrefixRange(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Cmd = []string{"curl"}
+	cfg.GroupPrefix = 33
+	if err := NewTopo(externalSnapshot()).Analyse(cfg).groupIP(cfg); err == nil {
+		t.Error("expect error of /33 for ipv4")
+	}
+	cfg.GroupPrefix, cfg.GroupPrefix6 = 0, 129
+	if err := NewTopo(externalSnapshot()).Analyse(cfg).groupIP(cfg); err == nil {
+		t.Error("expect error of /129 for ipv6")
+	}
+}
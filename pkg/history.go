@@ -0,0 +1,257 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// NSConnection is a connection in a network namespace, 0 for the host one
+type NSConnection struct {
+	NetNS uint64             `json:"netns"`
+	Conn  net.ConnectionStat `json:"conn"`
+}
+
+func (c NSConnection) key() string {
+	return fmt.Sprintf("%d/%s", c.NetNS, c.Conn.String())
+}
+
+// Connections flattens all indexes into a list of connections
+func (s *Snapshot) Connections() []NSConnection {
+	var conns []NSConnection
+	add := func(ns uint64, idx *PortIndex) {
+		for _, list := range idx.ListenPortConnections {
+			for _, conn := range list {
+				conns = append(conns, NSConnection{NetNS: ns, Conn: conn})
+			}
+		}
+		for _, conn := range idx.sockets() {
+			conns = append(conns, NSConnection{NetNS: ns, Conn: conn})
+		}
+	}
+	add(0, &s.PortIndex)
+	for ns, idx := range s.Namespaces {
+		add(ns, idx)
+	}
+	return conns
+}
+
+// HistoryFrame is a capture in the history, only the change against the previous frame is kept
+type HistoryFrame struct {
+	Time      time.Time `json:"time"`
+	Host      string    `json:"host,omitempty"`
+	Addrs     []string  `json:"addrs,omitempty"`
+	HostNetNS uint64    `json:"host_netns,omitempty"`
+
+	Processes []*Process        `json:"processes,omitempty"`
+	Exited    []int32           `json:"exited,omitempty"`
+	Opened    []NSConnection    `json:"opened,omitempty"`
+	Closed    []NSConnection    `json:"closed,omitempty"`
+	Hostnames map[string]string `json:"hostnames,omitempty"`
+}
+
+// historyState is the full state after replaying frames
+type historyState struct {
+	host      string
+	addrs     []string
+	hostNetNS uint64
+	processes map[int32]*Process
+	conns     map[string]NSConnection
+	hostnames map[string]string
+}
+
+func newHistoryState() *historyState {
+	return &historyState{
+		processes: map[int32]*Process{},
+		conns:     map[string]NSConnection{},
+		hostnames: map[string]string{},
+	}
+}
+
+func (st *historyState) apply(f *HistoryFrame) {
+	st.host, st.addrs, st.hostNetNS = f.Host, f.Addrs, f.HostNetNS
+	for _, pid := range f.Exited {
+		delete(st.processes, pid)
+	}
+	for _, p := range f.Processes {
+		st.processes[p.Pid] = p
+	}
+	for _, c := range f.Closed {
+		delete(st.conns, c.key())
+	}
+	for _, c := range f.Opened {
+		st.conns[c.key()] = c
+	}
+	for ip, name := range f.Hostnames {
+		st.hostnames[ip] = name
+	}
+}
+
+// diff returns the frame which changes the state into the snapshot
+func (st *historyState) diff(s *Snapshot, at time.Time) *HistoryFrame {
+	f := &HistoryFrame{
+		Time:      at,
+		Host:      s.Host,
+		Addrs:     s.Addrs,
+		HostNetNS: s.HostNetNS,
+		Hostnames: map[string]string{},
+	}
+	for pid, p := range s.PidProcess {
+		if old, ok := st.processes[pid]; !ok || !reflect.DeepEqual(old, p) {
+			f.Processes = append(f.Processes, p)
+		}
+	}
+	for pid := range st.processes {
+		if _, ok := s.PidProcess[pid]; !ok {
+			f.Exited = append(f.Exited, pid)
+		}
+	}
+
+	conns := map[string]NSConnection{}
+	for _, c := range s.Connections() {
+		conns[c.key()] = c
+		if _, ok := st.conns[c.key()]; !ok {
+			f.Opened = append(f.Opened, c)
+		}
+	}
+	for key, c := range st.conns {
+		if _, ok := conns[key]; !ok {
+			f.Closed = append(f.Closed, c)
+		}
+	}
+
+	for ip, name := range s.Hostnames {
+		if st.hostnames[ip] != name {
+			f.Hostnames[ip] = name
+		}
+	}
+
+	sort.Slice(f.Processes, func(i, j int) bool { return f.Processes[i].Pid < f.Processes[j].Pid })
+	sort.Slice(f.Exited, func(i, j int) bool { return f.Exited[i] < f.Exited[j] })
+	sort.Slice(f.Opened, func(i, j int) bool { return f.Opened[i].key() < f.Opened[j].key() })
+	sort.Slice(f.Closed, func(i, j int) bool { return f.Closed[i].key() < f.Closed[j].key() })
+	return f
+}
+
+// snapshot rebuilds the snapshot of the state
+func (st *historyState) snapshot() *Snapshot {
+	s := NewSnapshot()
+	s.Host, s.Addrs, s.HostNetNS = st.host, st.addrs, st.hostNetNS
+	for pid, p := range st.processes {
+		s.PidProcess[pid] = p
+		s.PidPort[pid] = NewPortSet()
+		s.PidListenPort[pid] = NewPortSet()
+	}
+	// in order of key, so the connections of a listen port are kept in order
+	keys := make([]string, 0, len(st.conns))
+	for key := range st.conns {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		c := st.conns[key]
+		idx := &s.PortIndex
+		if c.NetNS != 0 {
+			if _, ok := s.Namespaces[c.NetNS]; !ok {
+				s.Namespaces[c.NetNS] = NewPortIndex()
+			}
+			idx = s.Namespaces[c.NetNS]
+		}
+		s.addConnection(idx, c.Conn)
+	}
+	for ip, name := range st.hostnames {
+		s.Hostnames[ip] = name
+	}
+	return s
+}
+
+// Recorder appends snapshots into a history file, one frame per line
+type Recorder struct {
+	fd    *os.File
+	state *historyState
+}
+
+// OpenRecorder opens the history file, and continues from its last frame if existed
+func OpenRecorder(path string) (*Recorder, error) {
+	state := newHistoryState()
+	frames, err := ReadHistory(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, f := range frames {
+		state.apply(f)
+	}
+
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{fd: fd, state: state}, nil
+}
+
+// Record appends the change of the snapshot
+func (r *Recorder) Record(s *Snapshot, at time.Time) (*HistoryFrame, error) {
+	f := r.state.diff(s, at)
+	data, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.fd.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	r.state.apply(f)
+	return f, nil
+}
+
+func (r *Recorder) Close() error {
+	return r.fd.Close()
+}
+
+// ReadHistory reads all frames of the history file
+func ReadHistory(path string) ([]*HistoryFrame, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var frames []*HistoryFrame
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(nil, 1<<30)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		f := &HistoryFrame{}
+		if err := json.Unmarshal(scanner.Bytes(), f); err != nil {
+			return nil, &DecodeError{Path: fmt.Sprintf("%s:%d", path, line), Err: err}
+		}
+		frames = append(frames, f)
+	}
+	return frames, scanner.Err()
+}
+
+// ReplayHistory rebuilds the snapshot as it was at the time,
+// the last frame not after it is used
+func ReplayHistory(frames []*HistoryFrame, at time.Time) (*Snapshot, time.Time, error) {
+	state := newHistoryState()
+	var last time.Time
+	for _, f := range frames {
+		if f.Time.After(at) {
+			break
+		}
+		state.apply(f)
+		last = f.Time
+	}
+	if last.IsZero() {
+		return nil, last, fmt.Errorf("no capture before %s", at.Format(time.RFC3339))
+	}
+	return state.snapshot(), last, nil
+}
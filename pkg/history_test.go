@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	t0 := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+
+	first := externalSnapshot()
+	second := externalSnapshot()
+	second.PidProcess[20] = &Process{Pid: 20, Exec: "/usr/bin/worker", Cmdline: "worker"}
+	second.PidPort[20] = NewPortSet()
+	second.PidListenPort[20] = NewPortSet()
+	second.addConnection(&second.PortIndex, net.ConnectionStat{Pid: 20, Status: "LISTEN",
+		Laddr: net.Addr{IP: "0.0.0.0", Port: 9000}})
+	delete(second.PortConnection, 40004)
+	delete(second.PortPid, 40004)
+
+	recorder, err := OpenRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range []*Snapshot{first, second, second} {
+		if _, err := recorder.Record(s, t0.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorder.Close()
+
+	// continue from the last frame, nothing changed
+	recorder, err = OpenRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := recorder.Record(second, t0.Add(3*time.Minute))
+	recorder.Close()
+	if err != nil || len(frame.Processes) != 0 || len(frame.Opened) != 0 || len(frame.Closed) != 0 {
+		t.Errorf("expect empty frame, got %+v", frame)
+	}
+
+	frames, err := ReadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 4 {
+		t.Fatalf("got %d frames, want 4", len(frames))
+	}
+	if f := frames[1]; len(f.Processes) != 1 || len(f.Opened) != 1 || len(f.Closed) != 1 {
+		t.Errorf("bad delta frame %+v", f)
+	}
+
+	snapshot, at, err := ReplayHistory(frames, t0.Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !at.Equal(t0) || len(snapshot.PidProcess) != 1 || len(snapshot.PortConnection) != 5 {
+		t.Errorf("bad replay at %s: %d process %d connection", at, len(snapshot.PidProcess), len(snapshot.PortConnection))
+	}
+
+	snapshot, _, err = ReplayHistory(frames, t0.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.PidProcess) != 2 || len(snapshot.PortConnection) != 4 || snapshot.ListenPortPid[9000] != 20 {
+		t.Errorf("bad replay of last: %d process %d connection", len(snapshot.PidProcess), len(snapshot.PortConnection))
+	}
+
+	if _, _, err := ReplayHistory(frames, t0.Add(-time.Hour)); err == nil {
+		t.Error("expect error before the first capture")
+	}
+}
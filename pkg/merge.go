@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	gonet "net"
+	"sort"
+	"strconv"
+
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/sirupsen/logrus"
+)
+
+// HostSnapshot is a snapshot tagged with its host addresses
+type HostSnapshot struct {
+	Name     string
+	Addrs    []string
+	Snapshot *Snapshot
+}
+
+// NewHostSnapshot tags the snapshot, the recorded host and addresses are used if not given
+func NewHostSnapshot(snapshot *Snapshot, name string, addrs []string) *HostSnapshot {
+	if name == "" {
+		name = snapshot.Host
+	}
+	if len(addrs) == 0 {
+		addrs = snapshot.Addrs
+	}
+	return &HostSnapshot{Name: name, Addrs: addrs, Snapshot: snapshot}
+}
+
+// HostTopo is the topo of a single host in the multi-host topo
+type HostTopo struct {
+	Name  string
+	Addrs []string
+	Topo  *PSTopo
+}
+
+// CrossEdge is a connection from a process of a host to a process of another host
+type CrossEdge struct {
+	FromHost   int
+	From       int32
+	ToHost     int
+	To         int32
+	Connection net.ConnectionStat
+}
+
+// MultiTopo is the combined topo of several hosts
+type MultiTopo struct {
+	Hosts      []*HostTopo
+	CrossEdges map[string]*CrossEdge
+}
+
+// Edges returns the cross edges, in order of host, pid and then the connection
+func (mt *MultiTopo) Edges() []*CrossEdge {
+	list := make([]*CrossEdge, 0, len(mt.CrossEdges))
+	for _, e := range mt.CrossEdges {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.FromHost != b.FromHost {
+			return a.FromHost < b.FromHost
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.ToHost != b.ToHost {
+			return a.ToHost < b.ToHost
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return connLess(a.Connection, b.Connection)
+	})
+	return list
+}
+
+// Merge analyses each host with the same config,
+// and links the connections whose remote address belongs to another host
+func Merge(hosts []*HostSnapshot, cfg *Config) *MultiTopo {
+	mt := &MultiTopo{
+		CrossEdges: map[string]*CrossEdge{},
+	}
+
+	// an address on more than one host (e.g. 172.17.0.1 of docker0) can not tell the host
+	addrHost := map[string]int{}
+	shared := map[string]bool{}
+	for i, h := range hosts {
+		for _, addr := range h.Addrs {
+			ip := gonet.ParseIP(addr)
+			if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+				continue
+			}
+			if j, ok := addrHost[ip.String()]; ok && j != i {
+				shared[ip.String()] = true
+			}
+			addrHost[ip.String()] = i
+		}
+		mt.Hosts = append(mt.Hosts, &HostTopo{
+			Name:  h.Name,
+			Addrs: h.Addrs,
+			Topo:  NewTopo(h.Snapshot).Analyse(cfg),
+		})
+	}
+
+	for addr := range shared {
+		logrus.WithField("addr", addr).Warningln("address on several hosts, not matched across hosts")
+		delete(addrHost, addr)
+	}
+
+	for i, h := range hosts {
+		for _, idx := range h.Snapshot.Indexes() {
+			for _, conn := range idx.PortConnection {
+				ip := gonet.ParseIP(conn.Raddr.IP)
+				if ip == nil {
+					continue
+				}
+				j, ok := addrHost[ip.String()]
+				if !ok || j == i {
+					continue
+				}
+				mt.linkHost(i, j, conn)
+			}
+		}
+	}
+	return mt
+}
+
+// linkHost links the connection from host i to the listen process of host j
+func (mt *MultiTopo) linkHost(i int, j int, conn net.ConnectionStat) {
+	from, to := mt.Hosts[i].Topo, mt.Hosts[j].Topo
+
+	var peer int32
+	for _, idx := range to.Snapshot.Indexes() {
+		if pid, ok := idx.ListenPortPid[conn.Raddr.Port]; ok {
+			peer = pid
+			break
+		}
+	}
+	if conn.Pid == 0 || peer == 0 {
+		return
+	}
+
+	// filter, either side is matched
+	_, fromOk := from.PidSet[conn.Pid]
+	_, toOk := to.PidSet[peer]
+	if !fromOk && !toOk {
+		return
+	}
+	from.addPid(conn.Pid)
+	to.addPid(peer)
+
+	// the remote is a known process now, not an external ip
+	delete(from.IPConnSet, NewConnKey(conn))
+
+	key := strconv.Itoa(i) + ":" + conn.String()
+	mt.CrossEdges[key] = &CrossEdge{
+		FromHost:   i,
+		From:       conn.Pid,
+		ToHost:     j,
+		To:         peer,
+		Connection: conn,
+	}
+}
@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func TestMerge(t *testing.T) {
+	// same pid on both hosts
+	a := NewSnapshot()
+	a.Host, a.Addrs = "host-a", []string{"10.0.3.5"}
+	a.PidProcess[10] = &Process{Pid: 10, Exec: "/usr/bin/app", Cmdline: "app"}
+	a.PidPort[10] = NewPortSet()
+	a.PidListenPort[10] = NewPortSet()
+	a.addConnection(&a.PortIndex, net.ConnectionStat{Pid: 10, Status: "ESTABLISHED",
+		Laddr: net.Addr{IP: "10.0.3.5", Port: 40000}, Raddr: net.Addr{IP: "10.0.3.7", Port: 5432}})
+
+	b := NewSnapshot()
+	b.PidProcess[10] = &Process{Pid: 10, Exec: "/usr/bin/postgres", Cmdline: "postgres"}
+	b.PidPort[10] = NewPortSet()
+	b.PidListenPort[10] = NewPortSet()
+	b.addConnection(&b.PortIndex, net.ConnectionStat{Pid: 10, Status: "LISTEN",
+		Laddr: net.Addr{IP: "0.0.0.0", Port: 5432}})
+
+	cfg := NewConfig()
+	cfg.Cmd = []string{"app"}
+	mt := Merge([]*HostSnapshot{
+		NewHostSnapshot(a, "", nil),
+		NewHostSnapshot(b, "host-b", []string{"10.0.3.7"}),
+	}, cfg)
+
+	if mt.Hosts[0].Name != "host-a" || mt.Hosts[1].Name != "host-b" {
+		t.Errorf("bad host names %s %s", mt.Hosts[0].Name, mt.Hosts[1].Name)
+	}
+	if len(mt.CrossEdges) != 1 {
+		t.Fatalf("got %d cross edges, want 1", len(mt.CrossEdges))
+	}
+	if _, ok := mt.Hosts[1].Topo.PidSet[10]; !ok {
+		t.Error("postgres is not added to host-b")
+	}
+
+	data, err := (&DotRender{}).multiToData(mt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Clusters) != 2 || len(data.Clusters[1].Nodes) != 1 || data.Clusters[1].Nodes[0].ID != "h1_n10" {
+		t.Fatalf("bad host clusters %+v", data.Clusters)
+	}
+	found := false
+	for _, e := range data.Edges {
+		if e.From == "h0_n10:p40000" && e.To == "h1_n10:p5432" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("no cross host edge")
+	}
+}
+
+func TestMergeSharedAddr(t *testing.T) {
+	// the docker bridge address is on every host
+	a := NewSnapshot()
+	a.PidProcess[10] = &Process{Pid: 10, Exec: "/usr/bin/app", Cmdline: "app"}
+	a.PidPort[10] = NewPortSet()
+	a.PidListenPort[10] = NewPortSet()
+	a.addConnection(&a.PortIndex, net.ConnectionStat{Pid: 10, Status: "ESTABLISHED",
+		Laddr: net.Addr{IP: "172.17.0.2", Port: 40000}, Raddr: net.Addr{IP: "172.17.0.1", Port: 5432}})
+	a.PidProcess[11] = &Process{Pid: 11, Exec: "/usr/bin/postgres", Cmdline: "postgres"}
+	a.PidPort[11] = NewPortSet()
+	a.PidListenPort[11] = NewPortSet()
+	a.addConnection(&a.PortIndex, net.ConnectionStat{Pid: 11, Status: "LISTEN",
+		Laddr: net.Addr{IP: "0.0.0.0", Port: 5432}})
+
+	b := NewSnapshot()
+	b.PidProcess[10] = &Process{Pid: 10, Exec: "/usr/bin/postgres", Cmdline: "postgres"}
+	b.PidPort[10] = NewPortSet()
+	b.PidListenPort[10] = NewPortSet()
+	b.addConnection(&b.PortIndex, net.ConnectionStat{Pid: 10, Status: "LISTEN",
+		Laddr: net.Addr{IP: "0.0.0.0", Port: 5432}})
+
+	cfg := NewConfig()
+	cfg.Cmd = []string{"app"}
+	mt := Merge([]*HostSnapshot{
+		NewHostSnapshot(a, "host-a", []string{"10.0.3.5", "172.17.0.1"}),
+		NewHostSnapshot(b, "host-b", []string{"10.0.3.7", "172.17.0.1"}),
+	}, cfg)
+	if len(mt.CrossEdges) != 0 {
+		t.Errorf("got %d cross edges by the shared address, want 0", len(mt.CrossEdges))
+	}
+}
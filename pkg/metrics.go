@@ -0,0 +1,172 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	gonet "net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// metricFamily is a gauge with its series, keyed by the rendered labels
+type metricFamily struct {
+	Name   string
+	Help   string
+	series map[string]float64
+}
+
+func newMetricFamily(name string, help string) *metricFamily {
+	return &metricFamily{Name: name, Help: help, series: map[string]float64{}}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// add adds the value to the series of labels, given as name and value pairs
+func (m *metricFamily) add(value float64, labels ...string) {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	key := ""
+	if len(pairs) > 0 {
+		key = "{" + strings.Join(pairs, ",") + "}"
+	}
+	m.series[key] += value
+}
+
+// writeTo writes the family in prometheus text format, series sorted
+func (m *metricFamily) writeTo(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.Name, m.Help, m.Name); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := strconv.FormatFloat(m.series[key], 'g', -1, 64)
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", m.Name, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteName returns the local process on the other side if any,
+// otherwise the resolved name or the ip of the remote
+func (s *Snapshot) remoteName(conn NSConnection) string {
+	ip := gonet.ParseIP(conn.Conn.Raddr.IP)
+	if ip != nil && isPrivateIP(ip) {
+		for _, idx := range s.Indexes() {
+			if peer, ok := idx.findPeerByAddr(conn.Conn.Raddr); ok {
+				if p, ok := s.PidProcess[peer]; ok {
+					return processName(p)
+				}
+			}
+		}
+	}
+	if name := s.HostName(conn.Conn.Raddr.IP); name != "" {
+		return name
+	}
+	return conn.Conn.Raddr.IP
+}
+
+// WriteMetrics writes the gauges of the snapshot in prometheus text format
+func WriteMetrics(w io.Writer, s *Snapshot) error {
+	processes := newMetricFamily("pstopo_processes", "Number of processes.")
+	listens := newMetricFamily("pstopo_listen_sockets", "Number of listening sockets per process and port.")
+	established := newMetricFamily("pstopo_established_connections", "Number of established connections per process and remote.")
+	states := newMetricFamily("pstopo_connections", "Number of connections per process and state.")
+	external := newMetricFamily("pstopo_external_peers", "Number of distinct public peer addresses per process.")
+
+	processes.add(float64(len(s.PidProcess)))
+
+	name := func(pid int32) string {
+		if p, ok := s.PidProcess[pid]; ok {
+			return processName(p)
+		}
+		return ""
+	}
+	peers := map[string]map[string]bool{}
+	for _, c := range s.Connections() {
+		conn := c.Conn
+		process := name(conn.Pid)
+		if strings.EqualFold(conn.Status, "LISTEN") {
+			listens.add(1, "process", process, "port", strconv.Itoa(int(conn.Laddr.Port)))
+			continue
+		}
+		if conn.Status != "" {
+			states.add(1, "process", process, "state", conn.Status)
+		}
+		if conn.Raddr.IP == "" {
+			continue
+		}
+		if conn.Status == "ESTABLISHED" {
+			established.add(1, "process", process, "remote", s.remoteName(c))
+		}
+		if ip := gonet.ParseIP(conn.Raddr.IP); ip != nil && !ip.IsUnspecified() && !isPrivateIP(ip) {
+			if peers[process] == nil {
+				peers[process] = map[string]bool{}
+			}
+			peers[process][conn.Raddr.IP] = true
+		}
+	}
+	for process, ips := range peers {
+		external.add(float64(len(ips)), "process", process)
+	}
+
+	for _, m := range []*metricFamily{processes, listens, established, states, external} {
+		if err := m.writeTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Exporter serves the metrics of a fresh snapshot on each scrape
+type Exporter struct {
+	Kind string
+
+	take func(kind string) (*Snapshot, error)
+	mux  *http.ServeMux
+}
+
+func NewExporter(kind string) *Exporter {
+	e := &Exporter{
+		Kind: kind,
+		take: TakeSnapshot,
+		mux:  http.NewServeMux(),
+	}
+	e.mux.HandleFunc("GET /metrics", e.handleMetrics)
+	return e
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mux.ServeHTTP(w, r)
+}
+
+func (e *Exporter) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	snapshot, err := e.take(e.Kind)
+	if err != nil {
+		logrus.WithError(err).Errorln("take snapshot error")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	took := time.Since(start)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := WriteMetrics(w, snapshot); err != nil {
+		logrus.WithError(err).Warningln("write metrics error")
+		return
+	}
+	duration := newMetricFamily("pstopo_snapshot_duration_seconds", "Duration of taking the snapshot.")
+	duration.add(took.Seconds())
+	duration.writeTo(w)
+}
@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func TestExporterMetrics(t *testing.T) {
+	exporter := NewExporter("all")
+	exporter.take = func(kind string) (*Snapshot, error) {
+		snapshot := baselineSnapshot(100, 40000)
+		snapshot.addConnection(&snapshot.PortIndex, net.ConnectionStat{Pid: 100, Status: "CLOSE_WAIT",
+			Laddr: net.Addr{IP: "192.168.1.2", Port: 40010}, Raddr: net.Addr{IP: "1.1.1.1", Port: 443}})
+		return snapshot, nil
+	}
+	server := httptest.NewServer(exporter)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	body := string(data)
+
+	for _, line := range []string{
+		"# TYPE pstopo_listen_sockets gauge",
+		`pstopo_processes 2`,
+		`pstopo_listen_sockets{process="postgres",port="5432"} 1`,
+		`pstopo_established_connections{process="app",remote="postgres"} 1`,
+		`pstopo_established_connections{process="app",remote="dns.google"} 1`,
+		`pstopo_established_connections{process="postgres",remote="app"} 1`,
+		`pstopo_connections{process="app",state="ESTABLISHED"} 2`,
+		`pstopo_connections{process="app",state="CLOSE_WAIT"} 1`,
+		`pstopo_external_peers{process="app"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("no %q in metrics:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsAcceptedSockets(t *testing.T) {
+	// the accepted sockets of a server share its local port
+	snapshot := baselineSnapshot(100, 40000)
+	for i := uint32(1); i <= 5; i++ {
+		snapshot.addConnection(&snapshot.PortIndex, net.ConnectionStat{Pid: 101, Status: "ESTABLISHED",
+			Laddr: net.Addr{IP: "192.168.1.2", Port: 5432}, Raddr: net.Addr{IP: "93.184.216.1" + strconv.Itoa(int(i)), Port: 50000 + i}})
+	}
+	var buf strings.Builder
+	if err := WriteMetrics(&buf, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`pstopo_connections{process="postgres",state="ESTABLISHED"} 6`,
+		`pstopo_external_peers{process="postgres"} 5`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("no %q in metrics:\n%s", line, buf.String())
+		}
+	}
+
+	// the sockets are not dumped twice
+	if n := strings.Count(string(dumpSnapshot(t, snapshot)), `"93.184.216.1`); n != 1 {
+		t.Errorf("got %d remote sockets in the dump, want the one in PortConnection", n)
+	}
+}
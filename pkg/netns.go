@@ -0,0 +1,242 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	gonet "net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// PortIndex is the socket indexes of a network namespace.
+type PortIndex struct {
+	ListenPortConnections map[uint32][]net.ConnectionStat `yaml:"listen_port_connection"`
+	ListenPortPid         map[uint32]int32                `yaml:"listen_port_pid"`
+	PortConnection        map[uint32]net.ConnectionStat   `yaml:"port_connection"`
+	PortPid               map[uint32]int32                `yaml:"port_pid"`
+
+	// the sockets not listening whose local port is taken in PortConnection,
+	// e.g. the accepted ones of a listen port, only kept in memory
+	shadowed []net.ConnectionStat
+}
+
+func NewPortIndex() *PortIndex {
+	return &PortIndex{
+		ListenPortConnections: map[uint32][]net.ConnectionStat{},
+		ListenPortPid:         map[uint32]int32{},
+
+		PortConnection: map[uint32]net.ConnectionStat{},
+		PortPid:        map[uint32]int32{},
+	}
+}
+
+// sockets returns all the sockets not listening in order of the local port,
+// the ones sharing a local port are only seen in a captured snapshot, not a loaded one
+func (idx *PortIndex) sockets() []net.ConnectionStat {
+	list := make([]net.ConnectionStat, 0, len(idx.PortConnection)+len(idx.shadowed))
+	for _, conn := range idx.PortConnection {
+		list = append(list, conn)
+	}
+	list = append(list, idx.shadowed...)
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Laddr.Port != b.Laddr.Port {
+			return a.Laddr.Port < b.Laddr.Port
+		}
+		if a.Raddr.IP != b.Raddr.IP {
+			return a.Raddr.IP < b.Raddr.IP
+		}
+		return a.Raddr.Port < b.Raddr.Port
+	})
+	return list
+}
+
+// GetConnection returns the connection of local port.
+func (idx *PortIndex) GetConnection(port uint32) net.ConnectionStat {
+	return idx.PortConnection[port]
+}
+
+// findPeerByAddr returns the pid whose local address is exactly the addr.
+func (idx *PortIndex) findPeerByAddr(addr net.Addr) (int32, bool) {
+	conn, ok := idx.PortConnection[addr.Port]
+	if ok && conn.Laddr.IP == addr.IP {
+		return idx.PortPid[addr.Port], true
+	}
+	for _, conn := range idx.ListenPortConnections[addr.Port] {
+		if conn.Laddr.IP == addr.IP {
+			return conn.Pid, true
+		}
+	}
+	return 0, false
+}
+
+type socketKind struct {
+	file     string
+	family   uint32
+	sockType uint32
+}
+
+var (
+	kindTCP4 = socketKind{"tcp", syscall.AF_INET, syscall.SOCK_STREAM}
+	kindTCP6 = socketKind{"tcp6", syscall.AF_INET6, syscall.SOCK_STREAM}
+	kindUDP4 = socketKind{"udp", syscall.AF_INET, syscall.SOCK_DGRAM}
+	kindUDP6 = socketKind{"udp6", syscall.AF_INET6, syscall.SOCK_DGRAM}
+)
+
+// socketKinds is same as `gopsutil` connection kind, except the unix socket
+var socketKinds = map[string][]socketKind{
+	"all":   {kindTCP4, kindTCP6, kindUDP4, kindUDP6},
+	"inet":  {kindTCP4, kindTCP6, kindUDP4, kindUDP6},
+	"inet4": {kindTCP4, kindUDP4},
+	"inet6": {kindTCP6, kindUDP6},
+	"tcp":   {kindTCP4, kindTCP6},
+	"tcp4":  {kindTCP4},
+	"tcp6":  {kindTCP6},
+	"udp":   {kindUDP4, kindUDP6},
+	"udp4":  {kindUDP4},
+	"udp6":  {kindUDP6},
+}
+
+var tcpStatuses = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// SocketInodes returns the socket inode to fd of the pid.
+func (fs *ProcFS) SocketInodes(pid int32) (map[string]uint32, error) {
+	dir := fs.path(pid, "fd")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	inodes := map[string]uint32{}
+	for _, entry := range entries {
+		link, err := os.Readlink(fs.path(pid, "fd", entry.Name()))
+		if err != nil {
+			continue
+		}
+		// e.g. socket:[123456]
+		if !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		fd, _ := strconv.Atoi(entry.Name())
+		inodes[link[len("socket:["):len(link)-1]] = uint32(fd)
+	}
+	return inodes, nil
+}
+
+// Connections reads the sockets in the network namespace of `pid`, via `/proc/<pid>/net/*`,
+// and binds them to `pids` which are all in the same namespace.
+func (fs *ProcFS) Connections(kind string, pid int32, pids []int32) ([]net.ConnectionStat, error) {
+	kinds, ok := socketKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("invalid kind, %s", kind)
+	}
+
+	type owner struct {
+		pid int32
+		fd  uint32
+	}
+	owners := map[string]owner{}
+	for _, p := range pids {
+		inodes, err := fs.SocketInodes(p)
+		if err != nil {
+			continue
+		}
+		for inode, fd := range inodes {
+			owners[inode] = owner{pid: p, fd: fd}
+		}
+	}
+
+	var conns []net.ConnectionStat
+	for _, k := range kinds {
+		fd, err := os.Open(fs.path(pid, "net", k.file))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// e.g. no ipv6
+				continue
+			}
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(fd)
+		// skip the header
+		scanner.Scan()
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 {
+				continue
+			}
+			laddr, err := decodeAddress(k.family, fields[1])
+			if err != nil {
+				continue
+			}
+			raddr, err := decodeAddress(k.family, fields[2])
+			if err != nil {
+				continue
+			}
+			status := "NONE"
+			if k.sockType == syscall.SOCK_STREAM {
+				status = tcpStatuses[fields[3]]
+			}
+			o := owners[fields[9]]
+			conns = append(conns, net.ConnectionStat{
+				Fd:     o.fd,
+				Family: k.family,
+				Type:   k.sockType,
+				Laddr:  laddr,
+				Raddr:  raddr,
+				Status: status,
+				Pid:    o.pid,
+			})
+		}
+		err = scanner.Err()
+		fd.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return conns, nil
+}
+
+// decodeAddress decodes the address in `/proc/net/*`, e.g. "0500000A:0016" is 10.0.0.5:22
+func decodeAddress(family uint32, src string) (net.Addr, error) {
+	parts := strings.Split(src, ":")
+	if len(parts) != 2 {
+		return net.Addr{}, fmt.Errorf("does not contain port, %s", src)
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return net.Addr{}, fmt.Errorf("invalid port, %s", src)
+	}
+	decoded, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return net.Addr{}, err
+	}
+
+	// each 32 bits word is in host (little endian) order
+	if family == syscall.AF_INET && len(decoded) != 4 || family == syscall.AF_INET6 && len(decoded) != 16 {
+		return net.Addr{}, fmt.Errorf("invalid address, %s", src)
+	}
+	ip := make(gonet.IP, 0, len(decoded))
+	for i := 0; i < len(decoded); i += 4 {
+		ip = append(ip, decoded[i+3], decoded[i+2], decoded[i+1], decoded[i])
+	}
+	return net.Addr{IP: ip.String(), Port: uint32(port)}, nil
+}
@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+const tcpHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+func TestProcFSConnections(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "100")
+	os.MkdirAll(filepath.Join(dir, "net"), 0755)
+	os.MkdirAll(filepath.Join(dir, "fd"), 0755)
+	os.WriteFile(filepath.Join(dir, "net", "tcp"), []byte(tcpHeader+
+		"   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1\n"+
+		"   1: 020011AC:1F90 010011AC:9C40 01 00000000:00000000 00:00000000 00000000     0        0 1002 1\n"+
+		"   2: 020011AC:1F91 010011AC:9C41 06 00000000:00000000 00:00000000 00000000     0        0 0 1\n",
+	), 0644)
+	os.Symlink("socket:[1001]", filepath.Join(dir, "fd", "3"))
+	os.Symlink("socket:[1002]", filepath.Join(dir, "fd", "4"))
+	os.Symlink("/dev/null", filepath.Join(dir, "fd", "0"))
+
+	conns, err := NewProcFS(root).Connections("tcp", 100, []int32{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conns) != 3 {
+		t.Fatalf("got %d connections, want 3", len(conns))
+	}
+
+	want := []net.ConnectionStat{
+		{Fd: 3, Pid: 100, Status: "LISTEN", Laddr: net.Addr{IP: "0.0.0.0", Port: 8080}, Raddr: net.Addr{IP: "0.0.0.0"}},
+		{Fd: 4, Pid: 100, Status: "ESTABLISHED", Laddr: net.Addr{IP: "172.17.0.2", Port: 8080}, Raddr: net.Addr{IP: "172.17.0.1", Port: 40000}},
+		{Fd: 0, Pid: 0, Status: "TIME_WAIT", Laddr: net.Addr{IP: "172.17.0.2", Port: 8081}, Raddr: net.Addr{IP: "172.17.0.1", Port: 40001}},
+	}
+	for i, w := range want {
+		c := conns[i]
+		if c.Fd != w.Fd || c.Pid != w.Pid || c.Status != w.Status || c.Laddr != w.Laddr || c.Raddr != w.Raddr {
+			t.Errorf("connection %d = %+v, want %+v", i, c, w)
+		}
+	}
+}
+
+func TestNamespaceMatching(t *testing.T) {
+	const containerNS = 4026532200
+
+	// both host and container has a server on 8080,
+	// and the host client connects to the container one via bridge
+	snapshot := NewSnapshot()
+	snapshot.HostNetNS = 4026531993
+	for _, p := range []*Process{
+		{Pid: 10, Exec: "/usr/bin/client", Cmdline: "client", NetNS: 4026531993},
+		{Pid: 20, Exec: "/usr/bin/host-server", Cmdline: "host-server", NetNS: 4026531993},
+		{Pid: 30, Exec: "/usr/bin/container-server", Cmdline: "container-server", NetNS: containerNS},
+	} {
+		snapshot.PidProcess[p.Pid] = p
+		snapshot.PidPort[p.Pid] = NewPortSet()
+		snapshot.PidListenPort[p.Pid] = NewPortSet()
+	}
+	container := NewPortIndex()
+	snapshot.Namespaces[containerNS] = container
+
+	snapshot.addConnection(&snapshot.PortIndex, net.ConnectionStat{Pid: 20, Status: "LISTEN",
+		Laddr: net.Addr{IP: "0.0.0.0", Port: 8080}})
+	snapshot.addConnection(&snapshot.PortIndex, net.ConnectionStat{Pid: 10, Status: "ESTABLISHED",
+		Laddr: net.Addr{IP: "172.17.0.1", Port: 40000}, Raddr: net.Addr{IP: "172.17.0.2", Port: 8080}})
+	snapshot.addConnection(container, net.ConnectionStat{Pid: 30, Status: "LISTEN",
+		Laddr: net.Addr{IP: "0.0.0.0", Port: 8080}})
+	snapshot.addConnection(container, net.ConnectionStat{Pid: 30, Status: "ESTABLISHED",
+		Laddr: net.Addr{IP: "172.17.0.2", Port: 8080}, Raddr: net.Addr{IP: "172.17.0.1", Port: 40000}})
+
+	if pid, ok := snapshot.FindPeer(10, snapshot.GetConnection(40000)); !ok || pid != 30 {
+		t.Errorf("FindPeer = %d, want 30", pid)
+	}
+
+	cfg := NewConfig()
+	cfg.Cmd = []string{"client"}
+	topo := NewTopo(snapshot).Analyse(cfg)
+	for _, e := range topo.PidConnSet {
+		if e.From == 10 && e.To == 20 {
+			t.Errorf("client should not link to host server: %v", e)
+		}
+	}
+	if _, ok := topo.PidSet[30]; !ok {
+		t.Error("container server not in topo")
+	}
+}
+
+func TestNamespaceLoopback(t *testing.T) {
+	const containerNS = 4026532200
+
+	// the host client dials its own loopback, while a container listens on its loopback too
+	snapshot := NewSnapshot()
+	snapshot.HostNetNS = 4026531993
+	for _, p := range []*Process{
+		{Pid: 10, Exec: "/usr/bin/client", Cmdline: "client", NetNS: 4026531993},
+		{Pid: 20, Exec: "/usr/bin/host-server", Cmdline: "host-server", NetNS: 4026531993},
+		{Pid: 30, Exec: "/usr/bin/container-server", Cmdline: "container-server", NetNS: containerNS},
+	} {
+		snapshot.PidProcess[p.Pid] = p
+		snapshot.PidPort[p.Pid] = NewPortSet()
+		snapshot.PidListenPort[p.Pid] = NewPortSet()
+	}
+	container := NewPortIndex()
+	snapshot.Namespaces[containerNS] = container
+
+	snapshot.addConnection(&snapshot.PortIndex, net.ConnectionStat{Pid: 20, Status: "LISTEN",
+		Laddr: net.Addr{IP: "0.0.0.0", Port: 8080}})
+	snapshot.addConnection(&snapshot.PortIndex, net.ConnectionStat{Pid: 10, Status: "ESTABLISHED",
+		Laddr: net.Addr{IP: "127.0.0.1", Port: 40000}, Raddr: net.Addr{IP: "127.0.0.1", Port: 8080}})
+	snapshot.addConnection(container, net.ConnectionStat{Pid: 30, Status: "LISTEN",
+		Laddr: net.Addr{IP: "127.0.0.1", Port: 8080}})
+
+	// no accepted socket on the host is captured yet
+	if pid, ok := snapshot.FindPeer(10, snapshot.GetConnection(40000)); ok {
+		t.Errorf("FindPeer = %d, want none rather than the container", pid)
+	}
+
+	snapshot.addConnection(&snapshot.PortIndex, net.ConnectionStat{Pid: 20, Status: "ESTABLISHED",
+		Laddr: net.Addr{IP: "127.0.0.1", Port: 8080}, Raddr: net.Addr{IP: "127.0.0.1", Port: 40000}})
+	if pid, ok := snapshot.FindPeer(10, snapshot.GetConnection(40000)); !ok || pid != 20 {
+		t.Errorf("FindPeer = %d, want the host server 20", pid)
+	}
+}
@@ -0,0 +1,209 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// span kinds of OTLP
+const (
+	otlpSpanKindServer = 2
+	otlpSpanKindClient = 3
+)
+
+const otlpTracesPath = "/v1/traces"
+
+// OTLP/JSON payload of traces, only the fields used here
+
+type OTLPTraces struct {
+	ResourceSpans []*OTLPResourceSpans `json:"resourceSpans"`
+}
+
+type OTLPResourceSpans struct {
+	Resource   OTLPResource      `json:"resource"`
+	ScopeSpans []*OTLPScopeSpans `json:"scopeSpans"`
+}
+
+type OTLPResource struct {
+	Attributes []OTLPAttribute `json:"attributes"`
+}
+
+type OTLPScopeSpans struct {
+	Scope OTLPScope   `json:"scope"`
+	Spans []*OTLPSpan `json:"spans"`
+}
+
+type OTLPScope struct {
+	Name string `json:"name"`
+}
+
+type OTLPSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []OTLPAttribute `json:"attributes,omitempty"`
+}
+
+type OTLPAttribute struct {
+	Key   string    `json:"key"`
+	Value OTLPValue `json:"value"`
+}
+
+type OTLPValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func otlpString(key string, value string) OTLPAttribute {
+	return OTLPAttribute{Key: key, Value: OTLPValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int64) OTLPAttribute {
+	s := strconv.FormatInt(value, 10)
+	return OTLPAttribute{Key: key, Value: OTLPValue{IntValue: &s}}
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// ServiceGraphTraces turns each service edge of the topo into a trace,
+// a client span of the caller, and a server span of the callee if it is a local service.
+// So the service graph of the backend (e.g. the servicegraph connector) shows the dependency,
+// and `peer.service` names the remote which is not traced.
+func (tp *PSTopo) ServiceGraphTraces(at time.Time) *OTLPTraces {
+	start := strconv.FormatInt(at.UnixNano(), 10)
+	end := strconv.FormatInt(at.Add(time.Millisecond).UnixNano(), 10)
+
+	resources := map[string]*OTLPResourceSpans{}
+	traces := &OTLPTraces{ResourceSpans: []*OTLPResourceSpans{}}
+	add := func(service string, span *OTLPSpan) {
+		rs, ok := resources[service]
+		if !ok {
+			attrs := []OTLPAttribute{otlpString("service.name", service)}
+			if tp.Snapshot.Host != "" {
+				attrs = append(attrs, otlpString("host.name", tp.Snapshot.Host))
+			}
+			rs = &OTLPResourceSpans{
+				Resource:   OTLPResource{Attributes: attrs},
+				ScopeSpans: []*OTLPScopeSpans{{Scope: OTLPScope{Name: "pstopo"}}},
+			}
+			resources[service] = rs
+			traces.ResourceSpans = append(traces.ResourceSpans, rs)
+		}
+		rs.ScopeSpans[0].Spans = append(rs.ScopeSpans[0].Spans, span)
+	}
+
+	local := map[string]bool{}
+	for _, p := range tp.Snapshot.PidProcess {
+		local[processName(p)] = true
+	}
+
+	// a client span of each outbound dependency, the accepted side is the server span
+	for _, e := range tp.serviceEdges(true) {
+		traceID := randomHex(16)
+		client := &OTLPSpan{
+			TraceID:           traceID,
+			SpanID:            randomHex(8),
+			Name:              fmt.Sprintf("connect %s:%d", e.To, e.Port),
+			Kind:              otlpSpanKindClient,
+			StartTimeUnixNano: start,
+			EndTimeUnixNano:   end,
+			Attributes: []OTLPAttribute{
+				otlpString("peer.service", e.To),
+				otlpString("server.address", e.To),
+				otlpInt("server.port", int64(e.Port)),
+			},
+		}
+		add(e.From, client)
+
+		if !local[e.To] {
+			continue
+		}
+		add(e.To, &OTLPSpan{
+			TraceID:           traceID,
+			SpanID:            randomHex(8),
+			ParentSpanID:      client.SpanID,
+			Name:              fmt.Sprintf("accept :%d", e.Port),
+			Kind:              otlpSpanKindServer,
+			StartTimeUnixNano: start,
+			EndTimeUnixNano:   end,
+			Attributes:        []OTLPAttribute{otlpInt("server.port", int64(e.Port))},
+		})
+	}
+	return traces
+}
+
+// WriteOTLP writes the traces as OTLP/JSON
+func WriteOTLP(w io.Writer, traces *OTLPTraces) error {
+	data, err := json.MarshalIndent(traces, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// WriteOTLPFile writes the traces into a file
+func WriteOTLPFile(path string, traces *OTLPTraces) error {
+	fd, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return WriteOTLP(fd, traces)
+}
+
+// PostOTLP posts the traces to an OTLP/HTTP endpoint,
+// `/v1/traces` is used if the endpoint has no path
+func PostOTLP(ctx context.Context, client *http.Client, endpoint string, headers map[string]string, traces *OTLPTraces) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpTracesPath
+	}
+
+	var body bytes.Buffer
+	if err := WriteOTLP(&body, traces); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s %s", u, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
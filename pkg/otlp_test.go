@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestServiceGraphTraces(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Cmd = []string{"*"}
+	topo := NewTopo(baselineSnapshot(100, 40000)).Analyse(cfg)
+	traces := topo.ServiceGraphTraces(time.Unix(1700000000, 0))
+
+	// app -> postgres:5432 and app -> dns.google:53
+	spans := map[string][]*OTLPSpan{}
+	for _, rs := range traces.ResourceSpans {
+		service := *rs.Resource.Attributes[0].Value.StringValue
+		spans[service] = append(spans[service], rs.ScopeSpans[0].Spans...)
+	}
+	if len(spans["app"]) != 2 || len(spans["postgres"]) != 1 || len(spans) != 2 {
+		t.Fatalf("bad spans of services %v", spans)
+	}
+	server := spans["postgres"][0]
+	var client *OTLPSpan
+	for _, s := range spans["app"] {
+		if s.SpanID == server.ParentSpanID {
+			client = s
+		}
+	}
+	if client == nil || client.TraceID != server.TraceID || client.Kind != otlpSpanKindClient || server.Kind != otlpSpanKindServer {
+		t.Errorf("server span %+v is not linked to client", server)
+	}
+
+	path := filepath.Join(t.TempDir(), "traces.json")
+	if err := WriteOTLPFile(path, traces); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &OTLPTraces{}
+	if err := json.Unmarshal(data, decoded); err != nil || len(decoded.ResourceSpans) != 2 {
+		t.Errorf("bad file of traces: %v", err)
+	}
+
+	var received []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != otlpTracesPath || r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		received, _ = io.ReadAll(r.Body)
+	}))
+	defer receiver.Close()
+
+	headers := map[string]string{"X-Token": "secret"}
+	if err := PostOTLP(context.Background(), nil, receiver.URL, headers, traces); err != nil {
+		t.Fatal(err)
+	}
+	if string(received) != string(data) {
+		t.Error("received traces differ from the file")
+	}
+	if err := PostOTLP(context.Background(), nil, receiver.URL, nil, traces); err == nil {
+		t.Error("expect error of rejected post")
+	}
+}
+
+func TestServiceEdgesOutbound(t *testing.T) {
+	// with all, the accepted socket of postgres is linked back to app
+	topo := NewTopo(baselineSnapshot(100, 40000)).Analyse(&Config{All: true})
+	all, outbound := topo.ServiceEdges(), topo.serviceEdges(true)
+	inbound := BaselineEdge{From: "postgres", To: "app", Port: 40000}
+	if !slices.Contains(all, inbound) {
+		t.Errorf("expect the accepted socket kept for check and baseline, got %v", all)
+	}
+	if slices.Contains(outbound, inbound) || len(outbound) != len(all)-1 {
+		t.Errorf("expect only the outbound edges for otlp, got %v", outbound)
+	}
+}
@@ -0,0 +1,336 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	gonet "net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	RuleListen  = "listen"
+	RuleConnect = "connect"
+	RuleProcess = "process"
+)
+
+// Policy is a list of rules over processes, listen sockets and connections
+type Policy struct {
+	Rules []*PolicyRule `yaml:"rules" json:"rules"`
+}
+
+// PolicyRule selects items of a kind by `match`, and then
+//   - deny: any matched item is a violation
+//   - allow: any matched item not satisfying `allow` is a violation
+//   - require: no matched item is a violation
+type PolicyRule struct {
+	Name    string       `yaml:"name" json:"name"`
+	Kind    string       `yaml:"kind" json:"kind"`
+	Match   PolicyMatch  `yaml:"match" json:"match"`
+	Allow   *PolicyMatch `yaml:"allow" json:"allow,omitempty"`
+	Deny    bool         `yaml:"deny" json:"deny,omitempty"`
+	Require bool         `yaml:"require" json:"require,omitempty"`
+}
+
+// PolicyMatch is the conditions of an item, all given ones must be satisfied.
+// Process names are regexp of the whole name or executable base name,
+// addr is an ip, a cidr, or `any`, `loopback`, `private`, `public`.
+type PolicyMatch struct {
+	Process       string `yaml:"process" json:"process,omitempty"`
+	RemoteProcess string `yaml:"remote_process" json:"remote_process,omitempty"`
+	Port          uint32 `yaml:"port" json:"port,omitempty"`
+	Addr          string `yaml:"addr" json:"addr,omitempty"`
+	Unit          string `yaml:"unit" json:"unit,omitempty"`
+	Container     string `yaml:"container" json:"container,omitempty"`
+
+	processRe       *regexp.Regexp
+	remoteProcessRe *regexp.Regexp
+}
+
+func compileName(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// PolicyItem is a process, a listen socket or a connection to check
+type PolicyItem struct {
+	Process       *Process
+	RemoteProcess *Process
+	Port          uint32
+	Addr          string
+}
+
+func (i *PolicyItem) String() string {
+	var parts []string
+	if i.Process != nil {
+		parts = append(parts, fmt.Sprintf("%s(%d)", processName(i.Process), i.Process.Pid))
+	}
+	if i.Addr != "" || i.Port != 0 {
+		parts = append(parts, gonet.JoinHostPort(i.Addr, strconv.Itoa(int(i.Port))))
+	}
+	if i.RemoteProcess != nil {
+		parts = append(parts, fmt.Sprintf("%s(%d)", processName(i.RemoteProcess), i.RemoteProcess.Pid))
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// Violation is a failed rule with the item, or without item for `require`
+type Violation struct {
+	Rule    string `json:"rule"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Item    string `json:"item,omitempty"`
+}
+
+func processName(p *Process) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return filepath.Base(p.Exec)
+}
+
+// LoadPolicy reads a yaml (or json) policy file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// unknown keys are errors, a typo in match would match everything
+	policy := &Policy{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(policy); err != nil && err != io.EOF {
+		return nil, &DecodeError{Path: path, Err: err}
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// Validate checks the rule kinds, modes and patterns
+func (p *Policy) Validate() error {
+	for i, r := range p.Rules {
+		if r.Name == "" {
+			r.Name = "rule-" + strconv.Itoa(i+1)
+		}
+		switch r.Kind {
+		case RuleListen, RuleConnect, RuleProcess:
+		default:
+			return fmt.Errorf("rule %s: unknown kind %q", r.Name, r.Kind)
+		}
+		modes := 0
+		for _, set := range []bool{r.Deny, r.Require, r.Allow != nil} {
+			if set {
+				modes++
+			}
+		}
+		if modes != 1 {
+			return fmt.Errorf("rule %s: exactly one of deny, allow, require is needed", r.Name)
+		}
+		for _, m := range []*PolicyMatch{&r.Match, r.Allow} {
+			if m == nil {
+				continue
+			}
+			var err error
+			if m.processRe, err = compileName(m.Process); err != nil {
+				return fmt.Errorf("rule %s: %w", r.Name, err)
+			}
+			if m.remoteProcessRe, err = compileName(m.RemoteProcess); err != nil {
+				return fmt.Errorf("rule %s: %w", r.Name, err)
+			}
+			if m.Addr != "" && addrClass(m.Addr) == "" {
+				if _, _, err := gonet.ParseCIDR(m.Addr); err != nil && gonet.ParseIP(m.Addr) == nil {
+					return fmt.Errorf("rule %s: bad addr %q", r.Name, m.Addr)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func addrClass(addr string) string {
+	switch addr {
+	case "any", "loopback", "private", "public":
+		return addr
+	}
+	return ""
+}
+
+func matchName(re *regexp.Regexp, p *Process) bool {
+	if re == nil {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	return re.MatchString(p.Name) || re.MatchString(filepath.Base(p.Exec))
+}
+
+func matchAddr(pattern string, addr string) bool {
+	if pattern == "" {
+		return true
+	}
+	ip := gonet.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	switch addrClass(pattern) {
+	case "any":
+		return ip.IsUnspecified()
+	case "loopback":
+		return ip.IsLoopback()
+	case "private":
+		return !ip.IsLoopback() && !ip.IsUnspecified() && isPrivateIP(ip)
+	case "public":
+		return !ip.IsUnspecified() && !isPrivateIP(ip)
+	}
+	if _, block, err := gonet.ParseCIDR(pattern); err == nil {
+		return block.Contains(ip)
+	}
+	return ip.Equal(gonet.ParseIP(pattern))
+}
+
+func (m *PolicyMatch) match(item *PolicyItem) bool {
+	if !matchName(m.processRe, item.Process) || !matchName(m.remoteProcessRe, item.RemoteProcess) {
+		return false
+	}
+	if m.Port != 0 && m.Port != item.Port {
+		return false
+	}
+	if !matchAddr(m.Addr, item.Addr) {
+		return false
+	}
+	if m.Unit != "" && (item.Process == nil || item.Process.Unit != m.Unit && !strings.HasPrefix(item.Process.Unit, m.Unit+".")) {
+		return false
+	}
+	if m.Container != "" && (item.Process == nil || !strings.HasPrefix(item.Process.ContainerID, m.Container)) {
+		return false
+	}
+	return true
+}
+
+// policyItems collects the items of each kind from the snapshot
+func policyItems(snapshot *Snapshot) map[string][]*PolicyItem {
+	items := map[string][]*PolicyItem{}
+
+	for _, p := range snapshot.PidProcess {
+		items[RuleProcess] = append(items[RuleProcess], &PolicyItem{Process: p})
+	}
+
+	for _, idx := range snapshot.Indexes() {
+		for port, conns := range idx.ListenPortConnections {
+			for _, conn := range conns {
+				items[RuleListen] = append(items[RuleListen], &PolicyItem{
+					Process: snapshot.PidProcess[conn.Pid],
+					Port:    port,
+					Addr:    conn.Laddr.IP,
+				})
+			}
+		}
+	}
+
+	items[RuleConnect] = socketItems(snapshot)
+
+	for _, list := range items {
+		sort.Slice(list, func(i, j int) bool { return list[i].String() < list[j].String() })
+	}
+	return items
+}
+
+// inbound tells whether the edge is from an accepted socket of a listen port
+func (tp *PSTopo) inbound(e *TopoEdge) bool {
+	pid, ok := tp.Snapshot.PidIndex(e.From).ListenPortPid[e.Connection.Laddr.Port]
+	return ok && pid == e.From
+}
+
+// socketItems returns the connections of all processes from the sockets, to a process
+// if the peer is found or to an ip. Unlike the topo, a private ip without local peer
+// is kept, e.g. a database on another host.
+func socketItems(snapshot *Snapshot) []*PolicyItem {
+	seen := map[string]bool{}
+	var items []*PolicyItem
+	for _, idx := range snapshot.Indexes() {
+		for _, conn := range idx.sockets() {
+			process, ok := snapshot.PidProcess[conn.Pid]
+			if !ok || conn.Raddr.IP == "" || conn.Raddr.Port == 0 {
+				continue
+			}
+			item := &PolicyItem{Process: process, Port: conn.Raddr.Port, Addr: conn.Raddr.IP}
+			if peer, ok := snapshot.FindPeer(conn.Pid, conn); ok && peer != conn.Pid {
+				item.RemoteProcess = snapshot.PidProcess[peer]
+			}
+			if key := item.String(); !seen[key] {
+				seen[key] = true
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// connectItems returns the connections of the topo edges, to a process or to an ip,
+// and only the outbound ones if required
+func (tp *PSTopo) connectItems(outbound bool) []*PolicyItem {
+	var items []*PolicyItem
+	for _, e := range tp.ConnEdges() {
+		if outbound && tp.inbound(e) {
+			continue
+		}
+		items = append(items, &PolicyItem{
+			Process:       tp.Snapshot.PidProcess[e.From],
+			RemoteProcess: tp.Snapshot.PidProcess[e.To],
+			Port:          e.Connection.Raddr.Port,
+			Addr:          e.Connection.Raddr.IP,
+		})
+	}
+	for _, e := range tp.IPEdges() {
+		if outbound && tp.inbound(e) {
+			continue
+		}
+		items = append(items, &PolicyItem{
+			Process: tp.Snapshot.PidProcess[e.From],
+			Port:    e.Connection.Raddr.Port,
+			Addr:    e.Connection.Raddr.IP,
+		})
+	}
+	return items
+}
+
+// Check returns all violations of the snapshot
+func (p *Policy) Check(snapshot *Snapshot) ([]*Violation, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	items := policyItems(snapshot)
+
+	var violations []*Violation
+	for _, r := range p.Rules {
+		matched := 0
+		for _, item := range items[r.Kind] {
+			if !r.Match.match(item) {
+				continue
+			}
+			matched++
+			switch {
+			case r.Deny:
+				violations = append(violations, &Violation{Rule: r.Name, Kind: r.Kind, Message: "denied", Item: item.String()})
+			case r.Allow != nil && !r.Allow.match(item):
+				violations = append(violations, &Violation{Rule: r.Name, Kind: r.Kind, Message: "not allowed", Item: item.String()})
+			}
+		}
+		if r.Require && matched == 0 {
+			violations = append(violations, &Violation{Rule: r.Name, Kind: r.Kind, Message: "required but not found"})
+		}
+	}
+	return violations, nil
+}
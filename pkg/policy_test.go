@@ -0,0 +1,129 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+const testPolicy = `
+rules:
+  - name: no-public-listen
+    kind: listen
+    match: {addr: any}
+    deny: true
+  - name: curl-only-https
+    kind: connect
+    match: {process: curl}
+    allow: {port: 443}
+  - name: db-local-only
+    kind: connect
+    match: {port: 5432}
+    allow: {addr: loopback}
+  - name: need-sshd
+    kind: process
+    match: {process: sshd}
+    require: true
+  - name: need-curl
+    kind: process
+    match: {process: "cu.*"}
+    require: true
+`
+
+func TestPolicyCheck(t *testing.T) {
+	snapshot := externalSnapshot()
+	snapshot.PidProcess[20] = &Process{Pid: 20, Name: "nginx", Exec: "/usr/sbin/nginx", Cmdline: "nginx"}
+	snapshot.PidPort[20] = NewPortSet()
+	snapshot.PidListenPort[20] = NewPortSet()
+	snapshot.addConnection(&snapshot.PortIndex, net.ConnectionStat{Pid: 20, Status: "LISTEN",
+		Laddr: net.Addr{IP: "0.0.0.0", Port: 80}})
+	snapshot.addConnection(&snapshot.PortIndex, net.ConnectionStat{Pid: 20, Status: "ESTABLISHED",
+		Laddr: net.Addr{IP: "127.0.0.1", Port: 45000}, Raddr: net.Addr{IP: "127.0.0.1", Port: 5432}})
+
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(testPolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations, err := policy.Check(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := map[string]int{}
+	for _, v := range violations {
+		count[v.Rule]++
+	}
+	want := map[string]int{
+		"no-public-listen": 1,
+		// port 80 and 53
+		"curl-only-https": 2,
+		"need-sshd":       1,
+	}
+	if len(count) != len(want) {
+		t.Errorf("got violations %v, want %v", count, want)
+	}
+	for rule, n := range want {
+		if count[rule] != n {
+			t.Errorf("rule %s got %d violations, want %d", rule, count[rule], n)
+		}
+	}
+}
+
+func TestPolicyCheckRemoteHost(t *testing.T) {
+	// app connects to postgres on another host, which is not in the topo
+	snapshot := externalSnapshot()
+	snapshot.PidProcess[20] = &Process{Pid: 20, Name: "app", Exec: "/usr/bin/app", Cmdline: "app"}
+	snapshot.PidPort[20] = NewPortSet()
+	snapshot.PidListenPort[20] = NewPortSet()
+	snapshot.addConnection(&snapshot.PortIndex, net.ConnectionStat{Pid: 20, Status: "ESTABLISHED",
+		Laddr: net.Addr{IP: "10.0.3.2", Port: 45000}, Raddr: net.Addr{IP: "10.0.3.7", Port: 5432}})
+
+	policy := &Policy{Rules: []*PolicyRule{
+		{Name: "need-db", Kind: RuleConnect, Match: PolicyMatch{Process: "app", Port: 5432, Addr: "10.0.3.7"}, Require: true},
+		{Name: "no-private-db", Kind: RuleConnect, Match: PolicyMatch{Port: 5432, Addr: "private"}, Deny: true},
+	}}
+	violations, err := policy.Check(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 || violations[0].Rule != "no-private-db" || violations[0].Item != "app(20) -> 10.0.3.7:5432" {
+		t.Errorf("got violations %+v", violations)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	cases := []*PolicyRule{
+		{Kind: "socket", Deny: true},
+		{Kind: RuleListen},
+		{Kind: RuleListen, Deny: true, Require: true},
+		{Kind: RuleConnect, Deny: true, Match: PolicyMatch{Process: "("}},
+		{Kind: RuleConnect, Deny: true, Match: PolicyMatch{Addr: "internet"}},
+	}
+	for _, r := range cases {
+		if err := (&Policy{Rules: []*PolicyRule{r}}).Validate(); err == nil {
+			t.Errorf("expect error of %+v", r)
+		}
+	}
+}
+
+func TestLoadPolicyUnknownKey(t *testing.T) {
+	// a typo leaves the match empty, which would deny everything
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	text := "rules:\n  - name: no-curl\n    kind: connect\n    match: {proces: curl}\n    deny: true\n"
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadPolicy(path)
+	var decode *DecodeError
+	if !errors.As(err, &decode) || !strings.Contains(err.Error(), "proces") {
+		t.Errorf("expect unknown key error, got %v", err)
+	}
+}
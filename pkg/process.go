@@ -7,4 +7,32 @@ type Process struct {
 	Cmdline  string  `json:"cmdline"`
 	Parent   int32   `json:"parent"`
 	Children []int32 `json:"children"`
+
+	// owner, uid is -1 if unknown
+	User string `json:"user"`
+	UID  int32  `json:"uid"`
+
+	// container and namespace, from procfs
+	ContainerID string `json:"container_id"`
+	Runtime     string `json:"runtime"`
+	NetNS       uint64 `json:"netns"`
+	PidNS       uint64 `json:"pidns"`
+
+	// systemd unit, from cgroup path
+	Unit string `json:"unit"`
+
+	// exited before the capture, only its connections are seen by sampling
+	Exited bool `json:"exited,omitempty"`
+}
+
+// UnmarshalJSON keeps uid -1 if it is missing, e.g. in snapshots before uid,
+// rather than 0 as root
+func (p *Process) UnmarshalJSON(data []byte) error {
+	type process Process
+	decoded := process{UID: -1}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*p = Process(decoded)
+	return nil
 }
@@ -1,5 +1,152 @@
 package pkg
 
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RenderOptions is the options of rendering
+type RenderOptions struct {
+	// format of the renderer, e.g. `dot`, `svg`, `png` of the dot renderer, the default one if empty
+	Format string
+	// title of the graph, the default one if empty
+	Title string
+}
+
+// Render renders the topo into a writer
 type Render interface {
-	Write(topo *PSTopo, output string) error
+	Render(w io.Writer, topo *PSTopo, opts *RenderOptions) error
+	// ContentType returns the mime type of the format, empty if not supported
+	ContentType(format string) string
+}
+
+// RenderFactory creates a renderer
+type RenderFactory func() (Render, error)
+
+var (
+	rendersMu sync.RWMutex
+	renders   = map[string]RenderFactory{}
+)
+
+// RegisterRender registers the renderer by name, the existed one is replaced
+func RegisterRender(name string, factory RenderFactory) {
+	rendersMu.Lock()
+	defer rendersMu.Unlock()
+	renders[name] = factory
+}
+
+// NewRender creates the renderer registered by name
+func NewRender(name string) (Render, error) {
+	rendersMu.RLock()
+	factory, ok := renders[name]
+	rendersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown render %q, one of %v", name, RenderNames())
+	}
+	return factory()
+}
+
+// RenderNames returns the names of the registered renderers
+func RenderNames() []string {
+	rendersMu.RLock()
+	defer rendersMu.RUnlock()
+	var names []string
+	for name := range renders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewRenderFor returns the renderer registered by the format name (e.g. `json`),
+// or the dot renderer if it supports the format (e.g. `svg`)
+func NewRenderFor(format string) (Render, *RenderOptions, error) {
+	name, opts, err := renderNameFor(format)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := NewRender(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, opts, nil
+}
+
+// renderNameFor returns the name of the renderer of the format, see NewRenderFor
+func renderNameFor(format string) (string, *RenderOptions, error) {
+	if format == "" {
+		format = "dot"
+	}
+	rendersMu.RLock()
+	_, ok := renders[format]
+	rendersMu.RUnlock()
+	name := format
+	if !ok {
+		if _, ok := dotFormats[format]; !ok {
+			return "", nil, fmt.Errorf("unknown format %q", format)
+		}
+		name = "dot"
+	}
+	return name, &RenderOptions{Format: format}, nil
+}
+
+// RenderFile renders the topo into the file of exactly the path
+func RenderFile(r Render, topo *PSTopo, path string, opts *RenderOptions) error {
+	return writeFile(path, func(w io.Writer) error { return r.Render(w, topo, opts) })
+}
+
+func writeFile(path string, write func(w io.Writer) error) error {
+	fd, err := os.Create(path)
+	if err != nil {
+		return &RenderError{Stage: "write", Output: path, Err: err}
+	}
+	if err := write(fd); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return &RenderError{Stage: "write", Output: path, Err: err}
+	}
+	return nil
+}
+
+// JSONRender renders the topo as JSONGraph
+type JSONRender struct{}
+
+func (r *JSONRender) Render(w io.Writer, topo *PSTopo, opts *RenderOptions) error {
+	if err := json.NewEncoder(w).Encode(topo.JSONGraph()); err != nil {
+		return &RenderError{Stage: "write", Err: err}
+	}
+	return nil
+}
+
+func (r *JSONRender) ContentType(format string) string {
+	if format == "" || format == "json" {
+		return "application/json"
+	}
+	return ""
+}
+
+// OTLPRender renders the service dependencies of the topo as OTLP/JSON traces
+type OTLPRender struct{}
+
+func (r *OTLPRender) Render(w io.Writer, topo *PSTopo, opts *RenderOptions) error {
+	return WriteOTLP(w, topo.ServiceGraphTraces(time.Now()))
+}
+
+func (r *OTLPRender) ContentType(format string) string {
+	if format == "" || format == "otlp" {
+		return "application/json"
+	}
+	return ""
+}
+
+func init() {
+	RegisterRender("dot", func() (Render, error) { return NewDotRender() })
+	RegisterRender("json", func() (Render, error) { return &JSONRender{}, nil })
+	RegisterRender("otlp", func() (Render, error) { return &OTLPRender{}, nil })
 }
@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type countRender struct{}
+
+func (r *countRender) Render(w io.Writer, topo *PSTopo, opts *RenderOptions) error {
+	_, err := io.WriteString(w, strings.Repeat("*", len(topo.PidSet)))
+	return err
+}
+
+func (r *countRender) ContentType(format string) string {
+	return "text/plain"
+}
+
+func TestRenderRegistry(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Cmd = []string{"curl"}
+	topo := NewTopo(externalSnapshot()).Analyse(cfg)
+
+	cases := map[string]string{
+		"dot":  "digraph pstopo",
+		"json": `{"nodes":`,
+		"otlp": `"resourceSpans"`,
+	}
+	for name, prefix := range cases {
+		r, err := NewRender(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := r.Render(&buf, topo, &RenderOptions{Title: "my topo"}); err != nil {
+			t.Fatalf("render %s: %v", name, err)
+		}
+		if !strings.Contains(buf.String(), prefix) || r.ContentType("") == "" {
+			t.Errorf("bad %s output %s", name, buf.String())
+		}
+		if name == "dot" && !strings.Contains(buf.String(), `label="my topo"`) {
+			t.Error("title is not used")
+		}
+	}
+
+	if _, err := NewRender("gif"); err == nil {
+		t.Error("expect error of unknown render")
+	}
+	var renderErr *RenderError
+	if err := (&DotRender{}).Render(io.Discard, topo, &RenderOptions{Format: "gif"}); !errors.As(err, &renderErr) {
+		t.Errorf("expect render error of unknown format, got %v", err)
+	}
+
+	RegisterRender("count", func() (Render, error) { return &countRender{}, nil })
+	r, opts, err := NewRenderFor("count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "topo.txt")
+	if err := RenderFile(r, topo, path, opts); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "*" {
+		t.Errorf("got file %q %v", data, err)
+	}
+
+	if r, opts, err := NewRenderFor("svg"); err != nil || r.ContentType(opts.Format) != "image/svg+xml" {
+		t.Errorf("expect dot render for svg, got %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// namedNetwork is a user given name for an ip or a cidr block
+type namedNetwork struct {
+	Name  string
+	Block *net.IPNet
+}
+
+// newNamedNetwork parses an ip or a cidr block, a single ip is a full mask block.
+func newNamedNetwork(key string, name string) (namedNetwork, error) {
+	if !strings.Contains(key, "/") {
+		if strings.Contains(key, ":") {
+			key = key + "/128"
+		} else {
+			key = key + "/32"
+		}
+	}
+	_, block, err := net.ParseCIDR(key)
+	if err != nil {
+		return namedNetwork{}, err
+	}
+	return namedNetwork{Name: name, Block: block}, nil
+}
+
+// matchNetwork returns the name of the most specific block containing the ip.
+func matchNetwork(networks []namedNetwork, ip net.IP) string {
+	name, size := "", -1
+	for _, n := range networks {
+		if !n.Block.Contains(ip) {
+			continue
+		}
+		if ones, _ := n.Block.Mask.Size(); ones > size {
+			name, size = n.Name, ones
+		}
+	}
+	return name
+}
+
+// Resolver maps remote ip to a readable name, using (in order)
+// the user mapping, the hosts file and the reverse dns (if enabled).
+type Resolver struct {
+	hosts    map[string]string
+	networks []namedNetwork
+
+	dns     bool
+	timeout time.Duration
+	lookup  func(ctx context.Context, addr string) ([]string, error)
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+func NewResolver() *Resolver {
+	return &Resolver{
+		hosts:   map[string]string{},
+		timeout: time.Second,
+		lookup:  net.DefaultResolver.LookupAddr,
+		cache:   map[string]string{},
+	}
+}
+
+// LoadHosts reads an `/etc/hosts` style file, the first name of a line is used.
+func (r *Resolver) LoadHosts(path string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+		// keep the first one, same as the libc
+		if _, ok := r.hosts[ip.String()]; !ok {
+			r.hosts[ip.String()] = fields[1]
+		}
+	}
+	return scanner.Err()
+}
+
+// LoadMapping reads a json object of ip or cidr to name, e.g.
+// {"10.0.3.7": "prod-db", "3.0.0.0/9": "AWS us-east-1"}
+func (r *Resolver) LoadMapping(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	mapping := map[string]string{}
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return &DecodeError{Path: path, Err: err}
+	}
+	for key, name := range mapping {
+		if err := r.AddMapping(key, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddMapping adds a name for an ip or a cidr block.
+func (r *Resolver) AddMapping(key string, name string) error {
+	n, err := newNamedNetwork(key, name)
+	if err != nil {
+		return err
+	}
+	r.networks = append(r.networks, n)
+	return nil
+}
+
+// EnableDNS turns on the reverse dns lookup with the given timeout for each query.
+func (r *Resolver) EnableDNS(timeout time.Duration) {
+	r.dns = true
+	if timeout > 0 {
+		r.timeout = timeout
+	}
+}
+
+// Resolve returns the name of the ip, or "" if unknown.
+func (r *Resolver) Resolve(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+
+	// the most specific block wins
+	if name := matchNetwork(r.networks, ip); name != "" {
+		return name
+	}
+
+	if name, ok := r.hosts[ip.String()]; ok {
+		return name
+	}
+
+	if r.dns {
+		return r.reverse(ip.String())
+	}
+	return ""
+}
+
+func (r *Resolver) reverse(addr string) string {
+	r.mu.Lock()
+	name, ok := r.cache[addr]
+	r.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	names, err := r.lookup(ctx, addr)
+	if err != nil {
+		logrus.WithError(err).WithField("ip", addr).Debugln("reverse dns failed")
+	}
+	if len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+
+	// cache the miss too, to avoid waiting for timeout again
+	r.mu.Lock()
+	r.cache[addr] = name
+	r.mu.Unlock()
+	return name
+}
+
+// ResolveNames records the names of all remote ip into the snapshot,
+// so that the later reload will show the same names.
+func (s *Snapshot) ResolveNames(r *Resolver) {
+	if s.Hostnames == nil {
+		s.Hostnames = map[string]string{}
+	}
+
+	var ips []string
+	for _, conn := range s.PortConnection {
+		ips = append(ips, conn.Raddr.IP)
+	}
+	for _, conns := range s.ListenPortConnections {
+		for _, conn := range conns {
+			ips = append(ips, conn.Raddr.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		if ip == "" {
+			continue
+		}
+		if _, ok := s.Hostnames[ip]; ok {
+			continue
+		}
+		if name := r.Resolve(ip); name != "" {
+			s.Hostnames[ip] = name
+		}
+	}
+}
+
+// HostName returns the recorded name of the ip, or "" if unknown.
+func (s *Snapshot) HostName(ip string) string {
+	return s.Hostnames[ip]
+}
@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func TestResolverLookup(t *testing.T) {
+	dir := t.TempDir()
+	hosts := filepath.Join(dir, "hosts")
+	os.WriteFile(hosts, []byte("# comment\n10.0.3.7 db db.local\n10.0.3.7 other\n"), 0644)
+	names := filepath.Join(dir, "names.json")
+	os.WriteFile(names, []byte(`{"3.0.0.0/9": "AWS us-east-1", "3.1.2.3": "cdn"}`), 0644)
+
+	r := NewResolver()
+	if err := r.LoadHosts(hosts); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.LoadMapping(names); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	r.EnableDNS(0)
+	r.lookup = func(ctx context.Context, addr string) ([]string, error) {
+		calls++
+		if addr == "8.8.8.8" {
+			return []string{"dns.google."}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	cases := map[string]string{
+		"10.0.3.7": "db",
+		"3.4.5.6":  "AWS us-east-1",
+		"3.1.2.3":  "cdn",
+		"8.8.8.8":  "dns.google",
+		"1.1.1.1":  "",
+		"invalid":  "",
+	}
+	for ip, want := range cases {
+		if got := r.Resolve(ip); got != want {
+			t.Errorf("Resolve(%s) = %q, want %q", ip, got, want)
+		}
+	}
+
+	// cached, include the miss
+	r.Resolve("8.8.8.8")
+	r.Resolve("1.1.1.1")
+	if calls != 2 {
+		t.Errorf("reverse dns called %d times, want 2", calls)
+	}
+}
+
+func TestSnapshotResolveNames(t *testing.T) {
+	snapshot := NewSnapshot()
+	snapshot.PortConnection[40000] = net.ConnectionStat{
+		Laddr: net.Addr{IP: "192.168.1.2", Port: 40000},
+		Raddr: net.Addr{IP: "3.1.2.3", Port: 443},
+	}
+
+	r := NewResolver()
+	r.AddMapping("3.1.2.3", "cdn")
+	snapshot.ResolveNames(r)
+
+	data := dumpSnapshot(t, snapshot)
+	loaded := NewSnapshot()
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if name := loaded.HostName("3.1.2.3"); name != "cdn" {
+		t.Errorf("HostName = %q, want cdn", name)
+	}
+}
@@ -0,0 +1,164 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/sirupsen/logrus"
+)
+
+// ConnectionSample is a connection seen while sampling
+type ConnectionSample struct {
+	Pid       int32     `json:"pid"`
+	Laddr     net.Addr  `json:"laddr"`
+	Raddr     net.Addr  `json:"raddr"`
+	Status    string    `json:"status"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Hits      int       `json:"hits"`
+	// parent of the pid when first seen, to keep the connection of an exited process, 0 if unknown
+	Parent int32 `json:"parent,omitempty"`
+}
+
+func sampleKey(pid int32, laddr net.Addr, raddr net.Addr) string {
+	return fmt.Sprintf("%d|%s:%d|%s:%d", pid, laddr.IP, laddr.Port, raddr.IP, raddr.Port)
+}
+
+// Sampler polls connections at high frequency over a window,
+// to catch the short-lived connections a single capture misses.
+type Sampler struct {
+	Kind   string
+	Window time.Duration
+	Every  time.Duration
+
+	list func(ctx context.Context, kind string) ([]net.ConnectionStat, error)
+	ppid func(pid int32) (int32, error)
+}
+
+func NewSampler(kind string, window time.Duration, every time.Duration) *Sampler {
+	return &Sampler{
+		Kind:   kind,
+		Window: window,
+		Every:  every,
+		list:   net.ConnectionsWithContext,
+		ppid:   NewProcFS(defaultProcRoot).Ppid,
+	}
+}
+
+// Sample returns every distinct connection seen, and the number of polls
+func (s *Sampler) Sample(ctx context.Context) (map[string]*ConnectionSample, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.Window)
+	defer cancel()
+
+	samples := map[string]*ConnectionSample{}
+	parents := map[int32]int32{}
+	polls := 0
+	ticker := time.NewTicker(s.Every)
+	defer ticker.Stop()
+	for {
+		conns, err := s.list(ctx, s.Kind)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return nil, polls, err
+		}
+		polls++
+
+		now := time.Now()
+		for _, conn := range conns {
+			// listen socket is long-lived, the capture has it
+			if strings.EqualFold(conn.Status, "LISTEN") || conn.Pid == 0 {
+				continue
+			}
+			key := sampleKey(conn.Pid, conn.Laddr, conn.Raddr)
+			sample, ok := samples[key]
+			if !ok {
+				sample = &ConnectionSample{
+					Pid:       conn.Pid,
+					Laddr:     conn.Laddr,
+					Raddr:     conn.Raddr,
+					FirstSeen: now,
+				}
+				// read while the process is alive, it may exit before the capture
+				if _, ok := parents[conn.Pid]; !ok {
+					parents[conn.Pid], _ = s.ppid(conn.Pid)
+				}
+				sample.Parent = parents[conn.Pid]
+				samples[key] = sample
+			}
+			sample.Status = conn.Status
+			sample.LastSeen = now
+			sample.Hits++
+		}
+
+		select {
+		case <-ctx.Done():
+			logrus.WithField("polls", polls).WithField("connections", len(samples)).Debugln("sample done")
+			return samples, polls, nil
+		case <-ticker.C:
+		}
+	}
+	return samples, polls, nil
+}
+
+// AddSamples records the samples, and adds the connections missed by the capture
+func (s *Snapshot) AddSamples(samples map[string]*ConnectionSample, polls int) {
+	s.Samples = samples
+	s.SamplePolls = polls
+
+	// in order of the key, so the one kept for a shared local port is stable
+	keys := make([]string, 0, len(samples))
+	for key := range samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sample := samples[key]
+		if _, ok := s.PidProcess[sample.Pid]; !ok {
+			s.addExited(sample)
+		}
+		// keep the captured one
+		if _, ok := s.PortConnection[sample.Laddr.Port]; ok {
+			continue
+		}
+		s.addConnection(&s.PortIndex, net.ConnectionStat{
+			Pid:    sample.Pid,
+			Laddr:  sample.Laddr,
+			Raddr:  sample.Raddr,
+			Status: sample.Status,
+		})
+	}
+}
+
+// addExited adds the process only seen by sampling, under its parent if known
+func (s *Snapshot) addExited(sample *ConnectionSample) {
+	p := &Process{
+		Pid:      sample.Pid,
+		Name:     "exited",
+		Parent:   sample.Parent,
+		Children: []int32{},
+		UID:      -1,
+		NetNS:    s.HostNetNS,
+		Exited:   true,
+	}
+	if parent, ok := s.PidProcess[sample.Parent]; ok {
+		p.Name, p.Exec, p.User, p.UID = parent.Name, parent.Exec, parent.User, parent.UID
+		parent.Children = append(parent.Children, p.Pid)
+	}
+	s.PidProcess[p.Pid] = p
+	s.PidPort[p.Pid] = NewPortSet()
+	s.PidListenPort[p.Pid] = NewPortSet()
+}
+
+// SampleOf returns the sample of the connection, or nil if not sampled
+func (s *Snapshot) SampleOf(conn net.ConnectionStat) *ConnectionSample {
+	if s.Samples == nil {
+		return nil
+	}
+	return s.Samples[sampleKey(conn.Pid, conn.Laddr, conn.Raddr)]
+}
@@ -0,0 +1,122 @@
+package pkg
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func TestSampler(t *testing.T) {
+	long := net.ConnectionStat{Pid: 10, Status: "ESTABLISHED",
+		Laddr: net.Addr{IP: "192.168.1.2", Port: 41000}, Raddr: net.Addr{IP: "8.8.4.4", Port: 443}}
+	short := net.ConnectionStat{Pid: 10, Status: "ESTABLISHED",
+		Laddr: net.Addr{IP: "192.168.1.2", Port: 42000}, Raddr: net.Addr{IP: "1.1.1.1", Port: 443}}
+	listen := net.ConnectionStat{Pid: 10, Status: "LISTEN", Laddr: net.Addr{IP: "0.0.0.0", Port: 80}}
+
+	sampler := NewSampler("all", 50*time.Millisecond, 5*time.Millisecond)
+	calls := 0
+	sampler.list = func(ctx context.Context, kind string) ([]net.ConnectionStat, error) {
+		calls++
+		if calls == 2 {
+			return []net.ConnectionStat{long, short, listen}, nil
+		}
+		return []net.ConnectionStat{long, listen}, nil
+	}
+	sampler.ppid = func(pid int32) (int32, error) { return 1, nil }
+
+	samples, polls, err := sampler.Sample(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polls < 2 || polls != calls {
+		t.Fatalf("got %d polls of %d calls", polls, calls)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("got %d samples, want 2", len(samples))
+	}
+	if s := samples[sampleKey(10, long.Laddr, long.Raddr)]; s.Hits != polls || !s.LastSeen.After(s.FirstSeen) {
+		t.Errorf("bad long-lived sample %+v", s)
+	}
+	if s := samples[sampleKey(10, short.Laddr, short.Raddr)]; s.Hits != 1 || !s.LastSeen.Equal(s.FirstSeen) {
+		t.Errorf("bad short-lived sample %+v", s)
+	}
+
+	// the capture only has the long-lived one
+	snapshot := externalSnapshot()
+	snapshot.addConnection(&snapshot.PortIndex, long)
+	snapshot.AddSamples(samples, polls)
+	if _, ok := snapshot.PortConnection[42000]; !ok {
+		t.Error("short-lived connection is not added")
+	}
+
+	cfg := NewConfig()
+	cfg.Cmd = []string{"curl"}
+	data, err := (&DotRender{}).toData(NewTopo(snapshot).Analyse(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	weighted := 0
+	for _, e := range data.Edges {
+		if e.Attrs["penwidth"] != "" {
+			weighted++
+		}
+	}
+	if weighted != 2 {
+		t.Errorf("got %d weighted edges, want 2", weighted)
+	}
+}
+
+func TestAddSamplesExited(t *testing.T) {
+	// a crash-looping child of curl, exited before the capture
+	sampler := NewSampler("all", 20*time.Millisecond, 5*time.Millisecond)
+	sampler.list = func(ctx context.Context, kind string) ([]net.ConnectionStat, error) {
+		return []net.ConnectionStat{{Pid: 99, Status: "ESTABLISHED",
+			Laddr: net.Addr{IP: "192.168.1.2", Port: 43000}, Raddr: net.Addr{IP: "1.1.1.1", Port: 443}}}, nil
+	}
+	sampler.ppid = func(pid int32) (int32, error) { return 10, nil }
+	samples, polls, err := sampler.Sample(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := externalSnapshot()
+	snapshot.AddSamples(samples, polls)
+	p, ok := snapshot.PidProcess[99]
+	if !ok || !p.Exited || p.Parent != 10 || p.Name != "curl" {
+		t.Fatalf("exited process is not kept under the parent: %+v", p)
+	}
+	if snapshot.PortPid[43000] != 99 || !slices.Contains(snapshot.PidProcess[10].Children, 99) {
+		t.Error("connection of the exited process is not added")
+	}
+
+	cfg := NewConfig()
+	cfg.Cmd = []string{"curl"}
+	topo := NewTopo(snapshot).Analyse(cfg)
+	if _, ok := topo.PidSet[99]; !ok {
+		t.Error("exited process is not in the topo of its parent")
+	}
+}
+
+func TestAddSamplesOrder(t *testing.T) {
+	// two exited processes seen on the same local port, the kept one is stable
+	samples := map[string]*ConnectionSample{}
+	for _, pid := range []int32{98, 99} {
+		laddr := net.Addr{IP: "192.168.1.2", Port: 43000}
+		raddr := net.Addr{IP: "1.1.1.1", Port: 443 + uint32(pid)}
+		samples[sampleKey(pid, laddr, raddr)] = &ConnectionSample{Pid: pid, Parent: 10, Laddr: laddr, Raddr: raddr, Status: "ESTABLISHED", Hits: 1}
+	}
+	var first []byte
+	for i := 0; i < 20; i++ {
+		snapshot := externalSnapshot()
+		snapshot.AddSamples(samples, 1)
+		dump := dumpSnapshot(t, snapshot)
+		if first == nil {
+			first = dump
+		} else if string(dump) != string(first) {
+			t.Fatal("AddSamples differs between runs")
+		}
+	}
+}
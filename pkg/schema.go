@@ -0,0 +1,439 @@
+package pkg
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	gonet "net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ConfigJSON = "json"
+	ConfigYAML = "yaml"
+	ConfigTOML = "toml"
+)
+
+// ConfigFormat returns the format of config file by the extension, json by default
+func ConfigFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ConfigYAML
+	case ".toml":
+		return ConfigTOML
+	}
+	return ConfigJSON
+}
+
+// configField is a key of config in the schema, the types are the ones of json schema
+type configField struct {
+	Key  string
+	Type string
+	// type of the array items, or the object values
+	Items string
+	// range of the integer (items)
+	Min, Max int64
+	Enum     []string
+	// format of the object keys
+	KeyFormat   string
+	Description string
+}
+
+// configFields is the schema of config, it is published by ConfigSchema
+var configFields = []*configField{
+	{Key: "all", Type: "boolean", Description: "analyse all processes, it maybe hard on a busy host"},
+	{Key: "cmd", Type: "array", Items: "string", Description: "filter by a substring of cmdline, `*` for all processes"},
+	{Key: "port", Type: "array", Items: "integer", Min: 1, Max: 65535, Description: "filter by listen port, in any network namespace"},
+	{Key: "pid", Type: "array", Items: "integer", Min: 1, Max: 4194304, Description: "filter by pid"},
+	{Key: "container", Type: "array", Items: "string", Description: "filter by container id, or its prefix"},
+	{Key: "unit", Type: "array", Items: "string", Description: "filter by systemd unit, the suffix can be omitted, e.g. `nginx`"},
+	{Key: "cluster", Type: "string", Enum: []string{"", ClusterByContainer, ClusterByUnit, ClusterByNone},
+		Description: "cluster nodes in output by `container` (default), `unit` or `none`"},
+	{Key: "group", Type: "boolean", Description: "group external ip by the networks, or by the prefix block"},
+	{Key: "networks", Type: "object", Items: "string", KeyFormat: "cidr", Description: "names of the networks to group external ip, cidr to name"},
+	{Key: "group_prefix", Type: "integer", Min: 0, Max: 32, Description: "prefix length of the fallback ipv4 block for grouping, 24 if 0"},
+	{Key: "group_prefix6", Type: "integer", Min: 0, Max: 128, Description: "prefix length of the fallback ipv6 block for grouping, 64 if 0"},
+}
+
+// ConfigSchema returns the json schema of config
+func ConfigSchema() map[string]any {
+	properties := map[string]any{}
+	for _, f := range configFields {
+		p := map[string]any{"type": f.Type, "description": f.Description}
+		item := map[string]any{"type": f.Items}
+		if f.Max > 0 {
+			target := p
+			if f.Type == "array" {
+				target = item
+			}
+			target["minimum"], target["maximum"] = f.Min, f.Max
+		}
+		if len(f.Enum) > 0 {
+			p["enum"] = f.Enum
+		}
+		switch f.Type {
+		case "array":
+			p["items"] = item
+		case "object":
+			p["additionalProperties"] = item
+			if f.KeyFormat == "cidr" {
+				p["propertyNames"] = map[string]any{"pattern": `^[0-9a-fA-F.:]+/[0-9]+$`}
+			}
+		}
+		properties[f.Key] = p
+	}
+	return map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"$id":                  "https://github.com/FFengIll/pstopo/config.schema.json",
+		"title":                "pstopo config",
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// configValue is a parsed value of config file with the line, to validate before decoding
+type configValue struct {
+	Kind   string // the type of json schema, or `null`
+	Value  any
+	Line   int
+	Items  []*configValue
+	Keys   []string
+	Fields map[string]*configValue
+}
+
+func (v *configValue) set(key string, value *configValue) {
+	if v.Fields == nil {
+		v.Fields = map[string]*configValue{}
+	}
+	if _, ok := v.Fields[key]; !ok {
+		v.Keys = append(v.Keys, key)
+	}
+	v.Fields[key] = value
+}
+
+// parseConfigValue parses the config file in the format
+func parseConfigValue(format string, data []byte) (*configValue, error) {
+	switch format {
+	case ConfigYAML:
+		return parseYAMLValue(data)
+	case ConfigTOML:
+		return parseTOMLValue(data)
+	}
+	return parseJSONValue(data)
+}
+
+func parseYAMLValue(data []byte) (*configValue, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return &configValue{Kind: "object", Line: 1}, nil
+	}
+	return yamlValue(doc.Content[0])
+}
+
+func yamlValue(n *yaml.Node) (*configValue, error) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	v := &configValue{Line: n.Line}
+	switch n.Kind {
+	case yaml.MappingNode:
+		v.Kind = "object"
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			value, err := yamlValue(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			value.Line = n.Content[i].Line
+			v.set(n.Content[i].Value, value)
+		}
+	case yaml.SequenceNode:
+		v.Kind = "array"
+		for _, c := range n.Content {
+			item, err := yamlValue(c)
+			if err != nil {
+				return nil, err
+			}
+			v.Items = append(v.Items, item)
+		}
+	default:
+		switch n.ShortTag() {
+		case "!!null":
+			v.Kind = "null"
+		case "!!bool":
+			v.Kind = "boolean"
+		case "!!int":
+			v.Kind = "integer"
+		case "!!float":
+			v.Kind = "number"
+		default:
+			v.Kind = "string"
+		}
+		if err := n.Decode(&v.Value); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// parseJSONValue walks the tokens, to know the line of each value by the offset
+func parseJSONValue(data []byte) (*configValue, error) {
+	decoder := stdjson.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	lineAt := func(offset int64) int {
+		return 1 + bytes.Count(data[:offset], []byte("\n"))
+	}
+	var parse func() (*configValue, error)
+	parse = func() (*configValue, error) {
+		// skip the spaces and separators, to point to the value
+		offset := decoder.InputOffset()
+		for offset < int64(len(data)) && strings.ContainsRune(" \t\r\n,:", rune(data[offset])) {
+			offset++
+		}
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		v := &configValue{Line: lineAt(offset)}
+		switch t := token.(type) {
+		case stdjson.Delim:
+			if t == '{' {
+				v.Kind = "object"
+				for decoder.More() {
+					token, err := decoder.Token()
+					if err != nil {
+						return nil, err
+					}
+					value, err := parse()
+					if err != nil {
+						return nil, err
+					}
+					v.set(token.(string), value)
+				}
+			} else {
+				v.Kind = "array"
+				for decoder.More() {
+					item, err := parse()
+					if err != nil {
+						return nil, err
+					}
+					v.Items = append(v.Items, item)
+				}
+			}
+			// the closing delim
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+		case nil:
+			v.Kind = "null"
+		case bool:
+			v.Kind, v.Value = "boolean", t
+		case string:
+			v.Kind, v.Value = "string", t
+		case stdjson.Number:
+			if i, err := t.Int64(); err == nil {
+				v.Kind, v.Value = "integer", i
+			} else {
+				f, _ := t.Float64()
+				v.Kind, v.Value = "number", f
+			}
+		}
+		return v, nil
+	}
+
+	v, err := parse()
+	if err != nil {
+		return nil, jsonLineError(data, decoder, err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("line %d: unexpected data after the config", lineAt(decoder.InputOffset()))
+	}
+	return v, nil
+}
+
+// jsonLineError adds the line to the error of json
+func jsonLineError(data []byte, decoder *stdjson.Decoder, err error) error {
+	offset := decoder.InputOffset()
+	var syntax *stdjson.SyntaxError
+	if errors.As(err, &syntax) {
+		offset = syntax.Offset
+	}
+	offset = min(offset, int64(len(data)))
+	return fmt.Errorf("line %d: %w", 1+bytes.Count(data[:offset], []byte("\n")), err)
+}
+
+var tomlKeyPattern = regexp.MustCompile(`^\s*("?)([^"=\s]+)("?)\s*=`)
+var tomlTablePattern = regexp.MustCompile(`^\s*\[\s*"?([^"\]]+)"?\s*\]`)
+
+// parseTOMLValue decodes the toml, and finds the line of each key by scanning,
+// since the decoder does not keep the positions
+func parseTOMLValue(data []byte) (*configValue, error) {
+	var tree map[string]any
+	if _, err := toml.Decode(string(data), &tree); err != nil {
+		return nil, err
+	}
+
+	lines := map[string]int{}
+	table := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		if m := tomlTablePattern.FindStringSubmatch(line); m != nil && !strings.Contains(line, "=") {
+			table = m[1]
+			lines[table] = i + 1
+			continue
+		}
+		if m := tomlKeyPattern.FindStringSubmatch(line); m != nil {
+			key := m[2]
+			if table != "" {
+				key = table + "." + key
+			}
+			if _, ok := lines[key]; !ok {
+				lines[key] = i + 1
+			}
+		}
+	}
+
+	var convert func(value any, key string, line int) *configValue
+	convert = func(value any, key string, line int) *configValue {
+		if l, ok := lines[key]; ok {
+			line = l
+		}
+		v := &configValue{Line: line, Value: value}
+		switch t := value.(type) {
+		case map[string]any:
+			v.Kind, v.Value = "object", nil
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				sub := k
+				if key != "" {
+					sub = key + "." + k
+				}
+				v.set(k, convert(t[k], sub, line))
+			}
+		case []any:
+			v.Kind, v.Value = "array", nil
+			for _, item := range t {
+				v.Items = append(v.Items, convert(item, "", line))
+			}
+		case []map[string]any:
+			v.Kind, v.Value = "array", nil
+			for _, item := range t {
+				v.Items = append(v.Items, convert(item, "", line))
+			}
+		case bool:
+			v.Kind = "boolean"
+		case int64:
+			v.Kind = "integer"
+		case float64:
+			v.Kind = "number"
+		case string:
+			v.Kind = "string"
+		default:
+			v.Kind = "datetime"
+		}
+		return v
+	}
+	return convert(tree, "", 1), nil
+}
+
+// validateConfig checks the parsed config against the schema
+func validateConfig(root *configValue) []*ConfigError {
+	if root.Kind != "object" {
+		return []*ConfigError{{Line: root.Line, Message: "expect an object of config, got " + root.Kind}}
+	}
+	fields := map[string]*configField{}
+	for _, f := range configFields {
+		fields[f.Key] = f
+	}
+
+	var errs []*ConfigError
+	report := func(line int, key string, format string, args ...any) {
+		errs = append(errs, &ConfigError{Line: line, Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+	checkRange := func(f *configField, key string, v *configValue) {
+		if f.Max <= 0 {
+			return
+		}
+		i, ok := v.Value.(int64)
+		if !ok {
+			// yaml decodes the int as int
+			if n, isInt := v.Value.(int); isInt {
+				i, ok = int64(n), true
+			}
+		}
+		if !ok || i < f.Min || i > f.Max {
+			report(v.Line, key, "%v is out of range %d..%d", v.Value, f.Min, f.Max)
+		}
+	}
+
+	for _, key := range root.Keys {
+		v := root.Fields[key]
+		f, ok := fields[key]
+		if !ok {
+			report(v.Line, key, "unknown key, expect one of %s", strings.Join(configKeys(), ", "))
+			continue
+		}
+		if v.Kind == "null" {
+			continue
+		}
+		if v.Kind != f.Type {
+			report(v.Line, key, "expect %s, got %s", f.Type, v.Kind)
+			continue
+		}
+		switch f.Type {
+		case "array":
+			for i, item := range v.Items {
+				itemKey := fmt.Sprintf("%s[%d]", key, i)
+				if item.Kind != f.Items {
+					report(item.Line, itemKey, "expect %s, got %s", f.Items, item.Kind)
+					continue
+				}
+				checkRange(f, itemKey, item)
+			}
+		case "object":
+			for _, k := range v.Keys {
+				item := v.Fields[k]
+				itemKey := key + "." + k
+				if item.Kind != f.Items {
+					report(item.Line, itemKey, "expect %s, got %s", f.Items, item.Kind)
+				}
+				if f.KeyFormat == "cidr" {
+					if _, _, err := gonet.ParseCIDR(k); err != nil {
+						report(item.Line, itemKey, "bad cidr %q", k)
+					}
+				}
+			}
+		case "integer":
+			checkRange(f, key, v)
+		case "string":
+			if len(f.Enum) > 0 && !contains(f.Enum, fmt.Sprint(v.Value)) {
+				report(v.Line, key, "%q is not one of %s", v.Value, strings.Join(f.Enum[1:], ", "))
+			}
+		}
+	}
+	// toml keys are decoded into a map, so order by line
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+	return errs
+}
+
+func configKeys() []string {
+	var keys []string
+	for _, f := range configFields {
+		keys = append(keys, f.Key)
+	}
+	return keys
+}
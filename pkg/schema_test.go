@@ -0,0 +1,182 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name string, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	want := &Config{
+		Cmd:         []string{"nginx"},
+		Port:        []uint32{80, 443},
+		Pid:         []int32{},
+		Container:   []string{},
+		Unit:        []string{"api"},
+		Cluster:     ClusterByUnit,
+		Group:       true,
+		Networks:    map[string]string{"10.0.0.0/8": "internal"},
+		GroupPrefix: 16,
+	}
+	cases := map[string]string{
+		"config.json": `{"cmd": ["nginx"], "port": [80, 443], "unit": ["api"], "cluster": "unit",
+  "group": true, "networks": {"10.0.0.0/8": "internal"}, "group_prefix": 16}`,
+		"config.yaml": `cmd: [nginx]
+port:
+  - 80
+  - 443
+unit: [api]
+cluster: unit
+group: true
+networks:
+  10.0.0.0/8: internal
+group_prefix: 16
+`,
+		"config.toml": `cmd = ["nginx"]
+port = [80, 443]
+unit = ["api"]
+cluster = "unit"
+group = true
+group_prefix = 16
+
+[networks]
+"10.0.0.0/8" = "internal"
+`,
+	}
+	for name, text := range cases {
+		t.Run(name, func(t *testing.T) {
+			config, err := LoadConfig(writeConfig(t, name, text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config, want) {
+				t.Errorf("got %+v, want %+v", config, want)
+			}
+
+			// write back in the same format
+			path := filepath.Join(t.TempDir(), name)
+			if err := config.WriteTo(path); err != nil {
+				t.Fatal(err)
+			}
+			again, err := LoadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(again, want) {
+				t.Errorf("written back %+v, want %+v", again, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	cases := map[string]string{
+		"config.json": `{
+  "cmd": ["nginx"],
+  "port": [80,
+    70000],
+  "foo": 1,
+  "networks": {"bad": "x"},
+  "group": "yes"
+}`,
+		"config.yaml": `cmd: [nginx]
+port:
+  - 80
+  - 70000
+foo: 1
+networks:
+  bad: x
+group: "yes"
+`,
+		"config.toml": `cmd = ["nginx"]
+port = [80, 70000]
+foo = 1
+group = "yes"
+
+[networks]
+"bad" = "x"
+`,
+	}
+	want := map[string][]ConfigError{
+		"config.json": {
+			{Line: 4, Key: "port[1]"}, {Line: 5, Key: "foo"}, {Line: 6, Key: "networks.bad"}, {Line: 7, Key: "group"},
+		},
+		"config.yaml": {
+			{Line: 4, Key: "port[1]"}, {Line: 5, Key: "foo"}, {Line: 7, Key: "networks.bad"}, {Line: 8, Key: "group"},
+		},
+		"config.toml": {
+			// the items of a toml array are on the line of its key
+			{Line: 2, Key: "port[1]"}, {Line: 3, Key: "foo"}, {Line: 4, Key: "group"}, {Line: 7, Key: "networks.bad"},
+		},
+	}
+	for name, text := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, name, text))
+			var invalid *ConfigErrors
+			if !errors.As(err, &invalid) {
+				t.Fatalf("expect ConfigErrors, got %v", err)
+			}
+			var got []ConfigError
+			for _, e := range invalid.Errors {
+				got = append(got, ConfigError{Line: e.Line, Key: e.Key})
+			}
+			if !reflect.DeepEqual(got, want[name]) {
+				t.Errorf("got %v, want %v\n%v", got, want[name], err)
+			}
+		})
+	}
+
+	// syntax errors are not against the schema
+	_, err := LoadConfig(writeConfig(t, "config.yaml", "cmd: [nginx\n"))
+	var decode *DecodeError
+	if !errors.As(err, &decode) {
+		t.Errorf("expect DecodeError, got %v", err)
+	}
+}
+
+func TestDefaultConfigText(t *testing.T) {
+	for _, format := range []string{ConfigJSON, ConfigYAML, ConfigTOML} {
+		data, err := DefaultConfigText(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfig(writeConfig(t, "config."+format, string(data)))
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", format, err, data)
+		}
+		if config.All || len(config.Cmd) != 0 || config.GroupPrefix != 0 {
+			t.Errorf("%s: not the default %+v", format, config)
+		}
+	}
+}
+
+// TestPublishedSchema keeps config.schema.json in sync, regenerate it by `pstopo config schema`
+func TestPublishedSchema(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "config.schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published map[string]any
+	if err := json.Unmarshal(data, &published); err != nil {
+		t.Fatal(err)
+	}
+	var current map[string]any
+	data, _ = json.Marshal(ConfigSchema())
+	if err := json.Unmarshal(data, &current); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(published, current) {
+		t.Error("config.schema.json is out of date, regenerate it by `pstopo config schema`")
+	}
+}
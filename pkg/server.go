@@ -0,0 +1,231 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Server serves the snapshot and the topo over http.
+type Server struct {
+	// serve the snapshot file if given, otherwise take a fresh one
+	SnapshotPath string
+	Kind         string
+	// reuse the fresh snapshot within the ttl, `?fresh=1` to force a new one
+	CacheTTL time.Duration
+	// shared token as the agent, anyone can fetch if empty
+	Token string
+
+	mu       sync.Mutex
+	cached   *Snapshot
+	cachedAt time.Time
+
+	// renderers are shared by requests, so graphviz is created once
+	rendersMu sync.Mutex
+	renders   map[string]Render
+
+	mux *http.ServeMux
+}
+
+func NewServer(snapshotPath string, kind string, ttl time.Duration) *Server {
+	s := &Server{
+		SnapshotPath: snapshotPath,
+		Kind:         kind,
+		CacheTTL:     ttl,
+		mux:          http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /{$}", s.handleIndex)
+	s.mux.HandleFunc("GET /snapshot", s.handleSnapshot)
+	s.mux.HandleFunc("GET /topo", s.handleTopo)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logrus.WithField("url", r.URL.String()).Debugln("serve")
+	if !authorized(r, s.Token) {
+		logrus.WithField("remote", r.RemoteAddr).Warningln("unauthorized request")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// snapshot returns the cached or fresh snapshot
+func (s *Server) snapshot(fresh bool) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cached != nil && !fresh && (s.SnapshotPath != "" || time.Since(s.cachedAt) < s.CacheTTL) {
+		return s.cached, nil
+	}
+
+	var snapshot *Snapshot
+	var err error
+	if s.SnapshotPath != "" {
+		snapshot, err = LoadSnapshot(s.SnapshotPath)
+	} else {
+		snapshot, err = TakeSnapshot(s.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.cached, s.cachedAt = snapshot, time.Now()
+	return snapshot, nil
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, indexPage)
+}
+
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := s.snapshot(r.URL.Query().Get("fresh") != "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := snapshot.Dump()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (s *Server) handleTopo(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	cfg, err := ConfigFromQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	snapshot, err := s.snapshot(query.Get("fresh") != "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	topo := NewTopo(snapshot).Analyse(cfg)
+
+	render, opts, err := s.renderFor(query.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Title = query.Get("title")
+
+	// render into buffer first, so an error is still reported by status
+	var buf bytes.Buffer
+	if err := render.Render(&buf, topo, opts); err != nil {
+		logrus.WithError(err).Errorln("render topo error")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", render.ContentType(opts.Format))
+	w.Write(buf.Bytes())
+}
+
+// renderFor returns the shared renderer of the format, see NewRenderFor
+func (s *Server) renderFor(format string) (Render, *RenderOptions, error) {
+	name, opts, err := renderNameFor(format)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.rendersMu.Lock()
+	defer s.rendersMu.Unlock()
+	if r, ok := s.renders[name]; ok {
+		return r, opts, nil
+	}
+	r, err := NewRender(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.renders == nil {
+		s.renders = map[string]Render{}
+	}
+	s.renders[name] = r
+	return r, opts, nil
+}
+
+// Close releases the shared renderers
+func (s *Server) Close() error {
+	s.rendersMu.Lock()
+	defer s.rendersMu.Unlock()
+	var first error
+	for name, r := range s.renders {
+		if c, ok := r.(io.Closer); ok {
+			if err := c.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+		delete(s.renders, name)
+	}
+	return first
+}
+
+// ConfigFromQuery builds the config from query params, e.g. `?cmd=nginx&port=8080`,
+// all data is used if no filter given
+func ConfigFromQuery(query url.Values) (*Config, error) {
+	cfg := NewConfig()
+	cfg.Cmd = append(cfg.Cmd, query["cmd"]...)
+	cfg.Container = append(cfg.Container, query["container"]...)
+	cfg.Unit = append(cfg.Unit, query["unit"]...)
+	for _, value := range query["port"] {
+		port, err := strconv.ParseUint(strings.TrimPrefix(value, ":"), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("bad port %q", value)
+		}
+		cfg.Port = append(cfg.Port, uint32(port))
+	}
+	for _, value := range query["pid"] {
+		pid, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad pid %q", value)
+		}
+		cfg.Pid = append(cfg.Pid, int32(pid))
+	}
+	cfg.Cluster = query.Get("cluster")
+	cfg.Group = query.Get("group") != ""
+
+	cfg.All = len(cfg.Cmd) == 0 && len(cfg.Port) == 0 && len(cfg.Pid) == 0 &&
+		len(cfg.Container) == 0 && len(cfg.Unit) == 0
+	return cfg, nil
+}
+
+const indexPage = `<!DOCTYPE html>
+<html>
+<head><title>PSTopo</title></head>
+<body>
+<h1>PSTopo</h1>
+<form id="query">
+  cmd <input name="cmd">
+  port <input name="port">
+  pid <input name="pid">
+  <input type="submit" value="show">
+  <a href="/snapshot">snapshot</a>
+</form>
+<div id="topo"></div>
+<script>
+document.getElementById("query").onsubmit = function (e) {
+  e.preventDefault();
+  var params = new URLSearchParams();
+  for (var [k, v] of new FormData(e.target)) {
+    if (v) params.append(k, v);
+  }
+  params.append("format", "svg");
+  fetch("/topo?" + params).then(r => r.text()).then(svg => {
+    document.getElementById("topo").innerHTML = svg;
+  });
+};
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := os.WriteFile(path, dumpSnapshot(t, externalSnapshot()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewServer(path, "all", 0))
+	defer server.Close()
+
+	get := func(url string) (int, string) {
+		resp, err := http.Get(server.URL + url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("/snapshot")
+	if code != http.StatusOK || !strings.Contains(body, `"PidProcess"`) {
+		t.Errorf("GET /snapshot = %d %s", code, body)
+	}
+
+	code, body = get("/topo?cmd=curl")
+	if code != http.StatusOK || !strings.HasPrefix(body, "digraph pstopo") || !strings.Contains(body, "n10") {
+		t.Errorf("GET /topo = %d %s", code, body)
+	}
+
+	code, body = get("/topo?cmd=curl&format=json")
+	graph := &JSONGraph{}
+	if err := json.Unmarshal([]byte(body), graph); code != http.StatusOK || err != nil {
+		t.Fatalf("GET /topo json = %d %s", code, body)
+	}
+	if len(graph.Nodes) != 5 || graph.Nodes[0].Kind != NodeIP || len(graph.Edges) != 5 {
+		t.Errorf("bad json graph %s", body)
+	}
+
+	code, body = get("/topo?cmd=curl&group=1&format=json")
+	if code != http.StatusOK || !strings.Contains(body, `"kind":"group"`) {
+		t.Errorf("GET /topo group = %d %s", code, body)
+	}
+
+	if code, _ = get("/topo?port=abc"); code != http.StatusBadRequest {
+		t.Errorf("bad port got %d", code)
+	}
+	if code, _ = get("/topo?format=gif"); code != http.StatusBadRequest {
+		t.Errorf("bad format got %d", code)
+	}
+	// a pid not in the snapshot is an empty topo
+	if code, body = get("/topo?pid=999999&format=json"); code != http.StatusOK || !strings.Contains(body, `"nodes":[]`) {
+		t.Errorf("GET /topo unknown pid = %d %s", code, body)
+	}
+	if code, body = get("/"); code != http.StatusOK || !strings.Contains(body, "<html>") {
+		t.Errorf("GET / = %d", code)
+	}
+}
+
+func TestServerSharedRender(t *testing.T) {
+	server := NewServer("", "all", 0)
+	defer server.Close()
+	svg, opts, err := server.renderFor("svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot, _, err := server.renderFor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svg != dot || opts.Format != "svg" {
+		t.Errorf("expect the dot renderer shared by formats, got %p %p", svg, dot)
+	}
+	if err := server.Close(); err != nil || len(server.renders) != 0 {
+		t.Errorf("close renders: %v", err)
+	}
+}
+
+func TestServerToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := os.WriteFile(path, dumpSnapshot(t, externalSnapshot()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(path, "all", 0)
+	s.Token = "secret"
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	for auth, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/snapshot", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Authorization %q got %d, want %d", auth, resp.StatusCode, want)
+		}
+	}
+}
@@ -1,55 +1,68 @@
 package pkg
 
 import (
-	"sync"
+	"sort"
 
-	sets "github.com/deckarep/golang-set/v2"
 	jsoniter "github.com/json-iterator/go"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
+// PortSet is a set of ports kept in a sorted slice,
+// a process has a few ports, so the insertion is cheap and the iteration is ordered.
 type PortSet struct {
-	internal sets.Set[uint32]
-	sync.Once
+	ports []uint32
 }
 
 func NewPortSet() *PortSet {
-	return &PortSet{
-		internal: sets.NewSet[uint32](),
-	}
+	return &PortSet{}
 }
 
-func (set *PortSet) Iter() <-chan uint32 {
-	ch := make(chan uint32)
-	if set != nil {
-		go func() {
-			if set.internal != nil {
-				for elem := range set.internal.Iter() {
-					ch <- elem
-				}
-			}
-			close(ch)
-		}()
-	} else {
-		go func() {
-			close(ch)
-		}()
+// Ports returns the sorted ports, which should not be modified
+func (set *PortSet) Ports() []uint32 {
+	if set == nil {
+		return nil
 	}
+	return set.ports
+}
 
-	return ch
+func (set *PortSet) Len() int {
+	return len(set.Ports())
 }
 
+func (set *PortSet) Contains(port uint32) bool {
+	ports := set.Ports()
+	i := sort.Search(len(ports), func(i int) bool { return ports[i] >= port })
+	return i < len(ports) && ports[i] == port
+}
+
+// Add adds the port, and returns false if it existed
 func (set *PortSet) Add(port uint32) bool {
-	return set.internal.Add(port)
+	i := sort.Search(len(set.ports), func(i int) bool { return set.ports[i] >= port })
+	if i < len(set.ports) && set.ports[i] == port {
+		return false
+	}
+	set.ports = append(set.ports, 0)
+	copy(set.ports[i+1:], set.ports[i:])
+	set.ports[i] = port
+	return true
 }
 
-func (set *PortSet) MarshalJSON() ([]byte, error) {
-	var array []uint32
-	for item := range set.Iter() {
-		array = append(array, item)
+// Iter returns a closed channel of the ports, in order.
+//
+// Deprecated: range over Ports instead.
+func (set *PortSet) Iter() <-chan uint32 {
+	ports := set.Ports()
+	ch := make(chan uint32, len(ports))
+	for _, port := range ports {
+		ch <- port
 	}
-	return json.Marshal(array)
+	close(ch)
+	return ch
+}
+
+func (set *PortSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(set.Ports())
 }
 
 func (set *PortSet) UnmarshalJSON(data []byte) error {
@@ -58,11 +71,8 @@ func (set *PortSet) UnmarshalJSON(data []byte) error {
 	if err != nil {
 		return err
 	}
-	if set.internal == nil {
-		set.internal = sets.NewSet[uint32]()
-	}
 	for _, item := range array {
-		set.internal.Add(item)
+		set.Add(item)
 	}
 	return nil
 }
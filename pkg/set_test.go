@@ -0,0 +1,36 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPortSet(t *testing.T) {
+	set := NewPortSet()
+	for _, port := range []uint32{443, 80, 8080, 80} {
+		set.Add(port)
+	}
+	if !reflect.DeepEqual(set.Ports(), []uint32{80, 443, 8080}) {
+		t.Fatalf("expect sorted unique ports, got %v", set.Ports())
+	}
+	if !set.Contains(443) || set.Contains(22) {
+		t.Errorf("bad contains of %v", set.Ports())
+	}
+
+	data, err := set.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewPortSet()
+	if err := loaded.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Ports(), set.Ports()) {
+		t.Errorf("expect %v, got %v", set.Ports(), loaded.Ports())
+	}
+
+	var empty *PortSet
+	if empty.Len() != 0 || len(empty.Iter()) != 0 {
+		t.Errorf("expect nil set empty")
+	}
+}
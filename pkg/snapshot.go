@@ -2,9 +2,15 @@ package pkg
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	gonet "net"
 	"os"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
@@ -14,13 +20,30 @@ import (
 )
 
 type Snapshot struct {
-	PidProcess            map[int32]*Process              `yaml:"process"`
-	PidListenPort         map[int32]*PortSet              `yaml:"pid_listen_port"`
-	PidPort               map[int32]*PortSet              `yaml:"pid_port"`
-	ListenPortConnections map[uint32][]net.ConnectionStat `yaml:"listen_port_connection"`
-	ListenPortPid         map[uint32]int32                `yaml:"listen_port_pid"`
-	PortConnection        map[uint32]net.ConnectionStat   `yaml:"port_connection"`
-	PortPid               map[uint32]int32                `yaml:"port_pid"`
+	PidProcess    map[int32]*Process `yaml:"process"`
+	PidListenPort map[int32]*PortSet `yaml:"pid_listen_port"`
+	PidPort       map[int32]*PortSet `yaml:"pid_port"`
+	// socket indexes of the host network namespace
+	PortIndex
+	Hostnames map[string]string `yaml:"hostnames"`
+
+	// host name and interface addresses, to match connections across hosts
+	Host  string   `yaml:"host"`
+	Addrs []string `yaml:"addrs"`
+
+	// connections seen by sampling, with the number of polls
+	Samples     map[string]*ConnectionSample `yaml:"samples"`
+	SamplePolls int                          `yaml:"sample_polls"`
+
+	// socket indexes of other network namespaces (e.g. container)
+	HostNetNS  uint64                `yaml:"host_netns"`
+	Namespaces map[uint64]*PortIndex `yaml:"namespaces"`
+
+	// only the processes of the filter are inspected, see TakeTargetedSnapshot
+	Targeted bool `yaml:"targeted"`
+
+	// errors of inspecting processes while taking, not kept in file
+	InspectErrors []*ProcessError `yaml:"-" json:"-"`
 }
 
 func NewSnapshot() *Snapshot {
@@ -29,126 +52,412 @@ func NewSnapshot() *Snapshot {
 		PidListenPort: map[int32]*PortSet{},
 		PidPort:       map[int32]*PortSet{},
 
-		ListenPortConnections: map[uint32][]net.ConnectionStat{},
-		ListenPortPid:         map[uint32]int32{},
+		PortIndex: *NewPortIndex(),
+
+		Hostnames: map[string]string{},
 
-		PortConnection: map[uint32]net.ConnectionStat{},
-		PortPid:        map[uint32]int32{},
+		Namespaces: map[uint64]*PortIndex{},
 	}
 	return &s
 }
 
+// DefaultSnapshotWorkers is the number of workers to inspect processes by default
+var DefaultSnapshotWorkers = max(4, runtime.NumCPU())
+
 func TakeSnapshot(kind string) (*Snapshot, error) {
+	return TakeSnapshotWithContext(context.Background(), kind, 0)
+}
+
+// TakeSnapshotWithContext captures current system, the processes are inspected
+// by a pool of workers (DefaultSnapshotWorkers if not positive), and it stops on cancellation.
+func TakeSnapshotWithContext(ctx context.Context, kind string, workers int) (*Snapshot, error) {
 	snapshot := NewSnapshot()
 	log := logrus.StandardLogger()
 	log.Info("Take snapshot at {}", time.Now())
-	pids, err := process.PidsWithContext(context.Background())
+	pids, err := process.PidsWithContext(ctx)
 	if err != nil {
 		logrus.WithError(err).Warning("get pid error")
 		return nil, err
 	}
-	for _, pid := range pids {
-		p, _ := process.NewProcessWithContext(context.Background(), pid)
-		name, _ := p.Name()
-		exec, _ := p.Exe()
-		cmdline, _ := p.Cmdline()
-		children, _ := p.Children()
-		snapshot.PidListenPort[pid] = NewPortSet()
-		snapshot.PidPort[pid] = NewPortSet()
-		snapshot.PidProcess[pid] = &Process{
-			Pid:     p.Pid,
-			Name:    name,
-			Exec:    exec,
-			Cmdline: cmdline,
-			Parent: func() int32 {
-				parent, err := p.Parent()
-				if err != nil {
-					return 0
-				}
-				return parent.Pid
-			}(),
-			Children: func() []int32 {
-				res := []int32{}
-				for _, c := range children {
-					res = append(res, c.Pid)
-				}
-				return res
-			}(),
-		}
+	procfs := NewProcFS(defaultProcRoot)
+	processes, errs, err := inspectProcesses(ctx, procfs, pids, workers)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range processes {
+		snapshot.PidProcess[p.Pid] = p
+		snapshot.PidListenPort[p.Pid] = NewPortSet()
+		snapshot.PidPort[p.Pid] = NewPortSet()
+	}
+	snapshot.InspectErrors = errs
+	if len(snapshot.InspectErrors) > 0 {
+		logrus.WithField("errors", len(snapshot.InspectErrors)).Debugln("inspect process error")
 	}
 
+	if err := snapshot.collectConnections(ctx, procfs, kind); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// collectConnections fills the host info and the sockets of all network namespaces,
+// the processes should be added before.
+func (s *Snapshot) collectConnections(ctx context.Context, procfs *ProcFS, kind string) error {
+	s.Host, _ = os.Hostname()
+	s.Addrs = hostAddrs()
+
 	// here, `gopsutil` use Pid=0 to fetch All connections
-	connections, err := net.Connections(kind)
+	connections, err := net.ConnectionsWithContext(ctx, kind)
 	if err != nil {
 		logrus.WithError(err).Warning("get connection error")
-		return nil, err
+		return err
 	}
 	for _, conn := range connections {
+		s.addConnection(&s.PortIndex, conn)
+	}
 
-		if strings.EqualFold(conn.Status, "LISTEN") {
-			listenPort := conn.Laddr.Port
+	// sockets in other network namespaces are not visible in host `/proc/net/*`
+	if inode, err := procfs.Namespace(int32(os.Getpid()), "net"); err == nil {
+		s.HostNetNS = inode
+		s.collectNamespaces(procfs, kind)
+	}
+	return ctx.Err()
+}
+
+// inspectProcess reads the details of the process, nil if it exited
+func inspectProcess(ctx context.Context, procfs *ProcFS, pid int32) (*Process, []*ProcessError) {
+	p, err := process.NewProcessWithContext(ctx, pid)
+	if err != nil {
+		// exited
+		return nil, nil
+	}
+	var errs []*ProcessError
+	record := func(op string, err error) {
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		errs = append(errs, &ProcessError{Pid: pid, Op: op, Err: err})
+	}
+	name, err := p.NameWithContext(ctx)
+	record("name", err)
+	exec, err := p.ExeWithContext(ctx)
+	record("exe", err)
+	cmdline, err := p.CmdlineWithContext(ctx)
+	record("cmdline", err)
+	user, err := p.UsernameWithContext(ctx)
+	record("user", err)
+	uid := int32(-1)
+	if uids, err := p.UidsWithContext(ctx); err == nil && len(uids) > 0 {
+		uid = uids[0]
+	}
+	parent, err := p.PpidWithContext(ctx)
+	if err != nil {
+		parent = 0
+	}
 
-			snapshot.ListenPortPid[listenPort] = conn.Pid
+	info := &Process{
+		Pid:      pid,
+		Name:     name,
+		Exec:     exec,
+		Cmdline:  cmdline,
+		User:     user,
+		UID:      uid,
+		Parent:   parent,
+		Children: []int32{},
+	}
+	if err := procfs.Inspect(info); err != nil {
+		logrus.WithError(err).WithField("pid", pid).Debugln("inspect procfs error")
+		record("cgroup", err)
+	}
+	return info, errs
+}
 
-			conns := snapshot.ListenPortConnections[listenPort]
-			snapshot.ListenPortConnections[listenPort] = append(conns, conn)
+// inspectProcesses inspects the processes by a pool of workers.
+// The result is in order of pid, and the children are filled by the parent of each.
+func inspectProcesses(ctx context.Context, procfs *ProcFS, pids []int32, workers int) ([]*Process, []*ProcessError, error) {
+	return inspectPool(ctx, pids, workers, func(ctx context.Context, pid int32) (*Process, []*ProcessError) {
+		return inspectProcess(ctx, procfs, pid)
+	})
+}
 
-			set := snapshot.PidListenPort[conn.Pid]
-			set.Add(listenPort)
+// inspectFunc reads a process, nil if it exited
+type inspectFunc func(ctx context.Context, pid int32) (*Process, []*ProcessError)
 
-		} else {
-			localPort := conn.Laddr.Port
+// inspectPool runs inspect on the pids by a pool of workers (DefaultSnapshotWorkers if not positive),
+// and it stops on cancellation. The result is in order of pid, with the children filled.
+func inspectPool(ctx context.Context, pids []int32, workers int, inspect inspectFunc) ([]*Process, []*ProcessError, error) {
+	if workers <= 0 {
+		workers = DefaultSnapshotWorkers
+	}
+	pids = append([]int32{}, pids...)
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
 
-			snapshot.PortPid[localPort] = conn.Pid
+	processes := make([]*Process, len(pids))
+	errs := make([][]*ProcessError, len(pids))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				processes[i], errs[i] = inspect(ctx, pids[i])
+			}
+		}()
+	}
+feed:
+	for i := range pids {
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
 
-			snapshot.PortConnection[localPort] = conn
+	var list []*Process
+	var allErrs []*ProcessError
+	index := map[int32]*Process{}
+	for i, p := range processes {
+		allErrs = append(allErrs, errs[i]...)
+		if p == nil {
+			continue
+		}
+		list = append(list, p)
+		index[p.Pid] = p
+	}
+	// in order of pid, so the children are sorted
+	for _, p := range list {
+		if parent, ok := index[p.Parent]; ok && p.Parent != p.Pid {
+			parent.Children = append(parent.Children, p.Pid)
+		}
+	}
+	return list, allErrs, nil
+}
 
-			set, ok := snapshot.PidPort[conn.Pid]
-			if !ok {
-				logrus.WithField("pid", conn.Pid).Warningln("no such pid")
+// hostAddrs returns the addresses of all interfaces, except the loopback
+func hostAddrs() []string {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		logrus.WithError(err).Warning("get interface error")
+		return nil
+	}
+	var addrs []string
+	for _, i := range interfaces {
+		if isBridgeInterface(i.Name) {
+			continue
+		}
+		for _, addr := range i.Addrs {
+			ip, _, err := gonet.ParseCIDR(addr.Addr)
+			if err != nil || ip.IsLoopback() {
 				continue
 			}
-			set.Add(localPort)
+			addrs = append(addrs, ip.String())
+		}
+	}
+	return addrs
+}
+
+// bridgePrefixes are the interfaces of container networks, their addresses are the same on every host
+var bridgePrefixes = []string{"docker", "br-", "veth", "cni", "flannel", "cali", "virbr", "podman"}
+
+func isBridgeInterface(name string) bool {
+	return hasAnyPrefix(name, bridgePrefixes)
+}
+
+// collectNamespaces reads sockets of each network namespace other than the host one
+func (s *Snapshot) collectNamespaces(procfs *ProcFS, kind string) {
+	nsPids := map[uint64][]int32{}
+	for _, p := range s.Processes() {
+		if p.NetNS == 0 || p.NetNS == s.HostNetNS {
+			continue
+		}
+		nsPids[p.NetNS] = append(nsPids[p.NetNS], p.Pid)
+	}
+
+	for ns, pids := range nsPids {
+		var conns []net.ConnectionStat
+		var err error
+		// any process can be the entry of the namespace, try until success
+		for _, pid := range pids {
+			conns, err = procfs.Connections(kind, pid, pids)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			logrus.WithError(err).WithField("netns", ns).Warningln("get namespace connection error")
+			continue
+		}
+
+		idx := NewPortIndex()
+		for _, conn := range conns {
+			s.addConnection(idx, conn)
+		}
+		s.Namespaces[ns] = idx
+	}
+}
+
+// addConnection adds a connection to the index and the port set of its pid
+func (s *Snapshot) addConnection(idx *PortIndex, conn net.ConnectionStat) {
+	if strings.EqualFold(conn.Status, "LISTEN") {
+		listenPort := conn.Laddr.Port
+
+		idx.ListenPortPid[listenPort] = conn.Pid
+
+		conns := idx.ListenPortConnections[listenPort]
+		idx.ListenPortConnections[listenPort] = append(conns, conn)
+
+		set, ok := s.PidListenPort[conn.Pid]
+		if !ok {
+			logrus.WithField("pid", conn.Pid).Warningln("no such pid")
+			return
+		}
+		set.Add(listenPort)
+
+	} else {
+		localPort := conn.Laddr.Port
+
+		idx.PortPid[localPort] = conn.Pid
+
+		if shadowed, ok := idx.PortConnection[localPort]; ok {
+			idx.shadowed = append(idx.shadowed, shadowed)
+		}
+		idx.PortConnection[localPort] = conn
+
+		set, ok := s.PidPort[conn.Pid]
+		if !ok {
+			logrus.WithField("pid", conn.Pid).Warningln("no such pid")
+			return
+		}
+		set.Add(localPort)
+	}
+}
+
+// Index returns the socket indexes of the network namespace,
+// the host one is used for unknown namespace
+func (s *Snapshot) Index(netns uint64) *PortIndex {
+	if idx, ok := s.Namespaces[netns]; ok {
+		return idx
+	}
+	return &s.PortIndex
+}
+
+// PidIndex returns the socket indexes of the network namespace of the pid
+func (s *Snapshot) PidIndex(pid int32) *PortIndex {
+	if p, ok := s.PidProcess[pid]; ok {
+		return s.Index(p.NetNS)
+	}
+	return &s.PortIndex
+}
+
+// pidNetNS returns the network namespace of the pid, or 0 for the host
+func (s *Snapshot) pidNetNS(pid int32) uint64 {
+	if p, ok := s.PidProcess[pid]; ok {
+		if _, ok := s.Namespaces[p.NetNS]; ok {
+			return p.NetNS
 		}
 	}
+	return 0
+}
+
+// Indexes returns all socket indexes, the host one is the first and then in order of namespace
+func (s *Snapshot) Indexes() []*PortIndex {
+	indexes := []*PortIndex{&s.PortIndex}
+	for _, ns := range s.namespaceIDs() {
+		indexes = append(indexes, s.Namespaces[ns])
+	}
+	return indexes
+}
+
+// namespaceIDs returns the sorted inodes of the other network namespaces
+func (s *Snapshot) namespaceIDs() []uint64 {
+	ids := make([]uint64, 0, len(s.Namespaces))
+	for ns := range s.Namespaces {
+		ids = append(ids, ns)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// FindPeer returns the pid of the other side of the connection from `pid`.
+// The same namespace is preferred, by the exact address and then by the port,
+// and the other namespaces are only matched by the exact address (e.g. via veth or bridge).
+// A loopback or unspecified address never leaves the namespace.
+func (s *Snapshot) FindPeer(pid int32, conn net.ConnectionStat) (int32, bool) {
+	idx := s.PidIndex(pid)
+	if peer, ok := idx.findPeerByAddr(conn.Raddr); ok {
+		return peer, true
+	}
+	if peer, ok := idx.PortPid[conn.Raddr.Port]; ok {
+		return peer, true
+	}
+	if ip := gonet.ParseIP(conn.Raddr.IP); ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+		return 0, false
+	}
+	for _, other := range s.Indexes() {
+		if other == idx {
+			continue
+		}
+		if peer, ok := other.findPeerByAddr(conn.Raddr); ok {
+			return peer, true
+		}
+	}
+	return 0, false
+}
 
+// LoadSnapshot reads a snapshot file, plain or compressed by gzip or zstd
+func LoadSnapshot(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	snapshot, err := DecodeSnapshot(f)
+	if err != nil {
+		return nil, &DecodeError{Path: path, Err: err}
+	}
 	return snapshot, nil
 }
 
+// Processes returns all processes, in order of pid
 func (s *Snapshot) Processes() []*Process {
-	return func() []*Process {
-		var ps []*Process
-		for _, p := range s.PidProcess {
-			ps = append(ps, p)
-		}
-		return ps
-	}()
+	ps := make([]*Process, 0, len(s.PidProcess))
+	for _, p := range s.PidProcess {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Pid < ps[j].Pid })
+	return ps
 }
 
-func (s *Snapshot) DumpFile(filepath string) {
+// DumpFile writes the snapshot file, compressed by the extension, `.gz` or `.zst`
+func (s *Snapshot) DumpFile(filepath string) error {
 	log := logrus.New()
 	if strings.Compare(filepath, "") == 0 {
 		now := time.Now()
 		filepath = fmt.Sprintf("%s-%02d:%02d:%02d.snapshot.json", now.Format("2006-01-02"), now.Hour(), now.Minute(), now.Second())
 	}
 	log.Infof("snapshot to: %s", filepath)
-	bytes := s.Dump()
-	err := os.WriteFile(filepath, bytes, 0644)
-	if err != nil {
-		return
-	}
+	return s.writeFile(filepath)
 }
 
-func (s *Snapshot) Dump() []byte {
+// Dump returns the snapshot in json, see Encode to write into a stream
+func (s *Snapshot) Dump() ([]byte, error) {
 	var json = jsoniter.ConfigCompatibleWithStandardLibrary
-	data, _ := json.Marshal(s)
-	return data
+	return json.Marshal(s)
 }
 
-func (s *Snapshot) Print() []byte {
-	data := s.Dump()
+func (s *Snapshot) Print() ([]byte, error) {
+	data, err := s.Dump()
+	if err != nil {
+		return nil, err
+	}
 	fmt.Printf("%s", data)
-	return data
+	return data, nil
 }
 
 func (s *Snapshot) Copy(snapshot *Snapshot, pid int32) {
@@ -167,7 +476,3 @@ func (s *Snapshot) CopyLite(snapshot *Snapshot, pid int32) {
 	s.PidProcess[pid] = snapshot.PidProcess[pid]
 	s.PidListenPort[pid] = snapshot.PidListenPort[pid]
 }
-
-func (s *Snapshot) GetConnection(port uint32) net.ConnectionStat {
-	return s.PortConnection[port]
-}
@@ -1 +1,134 @@
 package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+func TestInspectProcesses(t *testing.T) {
+	// a fixed tree in a procfs fixture, pid i is a child of i/2, and every 7th pid exited
+	root := t.TempDir()
+	var pids []int32
+	for pid := int32(1); pid <= 64; pid++ {
+		pids = append(pids, pid)
+		if pid%7 == 0 {
+			continue
+		}
+		dir := strconv.Itoa(int(pid))
+		writeProcFixture(t, root, dir, "0::/system.slice/app.service\n", "4026531993", "4026531836")
+		stat := dir + " (app) S " + strconv.Itoa(int(pid/2)) + " 1 1 0 -1\n"
+		if err := os.WriteFile(filepath.Join(root, dir, "stat"), []byte(stat), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	procfs := NewProcFS(root)
+	inspect := func(ctx context.Context, pid int32) (*Process, []*ProcessError) {
+		parent, err := procfs.Ppid(pid)
+		if err != nil {
+			return nil, nil
+		}
+		p := &Process{Pid: pid, Parent: parent, Children: []int32{}}
+		if err := procfs.Inspect(p); err != nil {
+			return p, []*ProcessError{{Pid: pid, Op: "cgroup", Err: err}}
+		}
+		return p, nil
+	}
+
+	sequential, _, err := inspectPool(context.Background(), pids, 1, inspect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parallel, _, err := inspectPool(context.Background(), pids, 8, inspect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sequential) != 64-9 {
+		t.Errorf("expect %d processes, got %d", 64-9, len(sequential))
+	}
+	if !reflect.DeepEqual(sequential, parallel) {
+		t.Error("got different processes by 1 and 8 workers")
+	}
+	if p := parallel[1]; p.Pid != 2 || !reflect.DeepEqual(p.Children, []int32{4, 5}) || p.Unit != "app.service" {
+		t.Errorf("bad process %+v", p)
+	}
+
+	// children are filled by the parent, on the live host
+	self, parent := int32(os.Getpid()), int32(os.Getppid())
+	live, _, err := inspectProcesses(context.Background(), NewProcFS(defaultProcRoot), []int32{self, parent}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, p := range live {
+		found = found || p.Pid == parent && slices.Contains(p.Children, self)
+	}
+	if !found {
+		t.Errorf("pid %d is not in children of parent %d", self, parent)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := TakeSnapshotWithContext(ctx, "all", 4); !errors.Is(err, context.Canceled) {
+		t.Errorf("expect canceled, got %v", err)
+	}
+}
+
+// inspectSequential is the per-pid loop before the pool, as the baseline of the benchmark:
+// the children of each process are read by gopsutil rather than filled by the parent.
+func inspectSequential(procfs *ProcFS, pids []int32) []*Process {
+	var list []*Process
+	for _, pid := range pids {
+		p, err := process.NewProcess(pid)
+		if err != nil {
+			continue
+		}
+		info := &Process{Pid: pid, UID: -1, Children: []int32{}}
+		info.Name, _ = p.Name()
+		info.Exec, _ = p.Exe()
+		info.Cmdline, _ = p.Cmdline()
+		info.User, _ = p.Username()
+		if uids, err := p.Uids(); err == nil && len(uids) > 0 {
+			info.UID = uids[0]
+		}
+		if parent, err := p.Parent(); err == nil {
+			info.Parent = parent.Pid
+		}
+		children, _ := p.Children()
+		for _, c := range children {
+			info.Children = append(info.Children, c.Pid)
+		}
+		procfs.Inspect(info)
+		list = append(list, info)
+	}
+	return list
+}
+
+func BenchmarkInspectProcesses(b *testing.B) {
+	pids, err := process.Pids()
+	if err != nil {
+		b.Skip(err)
+	}
+	procfs := NewProcFS(defaultProcRoot)
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			inspectSequential(procfs, pids)
+		}
+	})
+	for _, workers := range []int{1, 4, 16} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := inspectProcesses(context.Background(), procfs, pids, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
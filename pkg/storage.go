@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compression of snapshot files, by the extension on dump, and by the magic bytes on load
+const (
+	CompressNone = ""
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+// compressExt is the extension of files written in the compression
+var compressExt = map[string]string{CompressNone: "", CompressGzip: ".gz", CompressZstd: ".zst"}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// CompressionOf is the compression of the path by extension, `.gz` or `.zst`
+func CompressionOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gz", ".gzip":
+		return CompressGzip
+	case ".zst", ".zstd":
+		return CompressZstd
+	}
+	return CompressNone
+}
+
+// closers are called in order, e.g. the compressor and then the file
+type closers []func() error
+
+func (cs closers) Close() error {
+	var first error
+	for _, c := range cs {
+		if err := c(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+type writeCloser struct {
+	io.Writer
+	closers
+}
+
+// decompress detects gzip or zstd by the magic bytes, or reads it as is
+func decompress(r io.Reader) (io.Reader, func() error, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, func() error { zr.Close(); return nil }, nil
+	}
+	return br, func() error { return nil }, nil
+}
+
+// CreateSnapshotFile creates a snapshot file to write, compressed by the extension
+func CreateSnapshotFile(path string) (io.WriteCloser, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	switch CompressionOf(path) {
+	case CompressGzip:
+		zw := gzip.NewWriter(f)
+		return &writeCloser{Writer: zw, closers: closers{zw.Close, f.Close}}, nil
+	case CompressZstd:
+		zw, err := zstd.NewWriter(f, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &writeCloser{Writer: zw, closers: closers{zw.Close, f.Close}}, nil
+	}
+	return &writeCloser{Writer: f, closers: closers{f.Close}}, nil
+}
+
+// DecodeSnapshot decodes a snapshot from the stream, without reading it all into memory first.
+// Compressed input is detected and decompressed.
+func DecodeSnapshot(r io.Reader) (*Snapshot, error) {
+	r, closeReader, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReader()
+
+	snapshot := NewSnapshot()
+	if err := json.NewDecoder(r).Decode(snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// writeFile writes the snapshot file, compressed by the extension
+func (s *Snapshot) writeFile(path string) error {
+	f, err := CreateSnapshotFile(path)
+	if err != nil {
+		return err
+	}
+	if err := s.Encode(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Encode writes the snapshot in json into the stream
+func (s *Snapshot) Encode(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s)
+}
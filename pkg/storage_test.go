@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// dumpSnapshot returns the snapshot in json, or fails the test
+func dumpSnapshot(t testing.TB, s *Snapshot) []byte {
+	t.Helper()
+	data, err := s.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestSnapshotCompression(t *testing.T) {
+	snapshot, err := GenerateSnapshot(DefaultFakeSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dumpSnapshot(t, snapshot)
+
+	dir := t.TempDir()
+	sizes := map[string]int64{}
+	for _, name := range []string{"snapshot.json", "snapshot.json.gz", "snapshot.json.zst"} {
+		path := filepath.Join(dir, name)
+		if err := snapshot.DumpFile(path); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[name] = info.Size()
+
+		loaded, err := LoadSnapshot(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := dumpSnapshot(t, loaded); !bytes.Equal(got, want) {
+			t.Errorf("%s: loaded snapshot differs", name)
+		}
+
+		// detected by the magic bytes, whatever the extension
+		renamed := filepath.Join(dir, name+".renamed")
+		if err := os.Rename(path, renamed); err != nil {
+			t.Fatal(err)
+		}
+		if loaded, err = LoadSnapshot(renamed); err != nil || !bytes.Equal(dumpSnapshot(t, loaded), want) {
+			t.Errorf("%s: detect by magic bytes: %v", name, err)
+		}
+	}
+	for _, name := range []string{"snapshot.json.gz", "snapshot.json.zst"} {
+		if sizes[name] >= sizes["snapshot.json"] {
+			t.Errorf("%s is %d bytes, not smaller than %d of json", name, sizes[name], sizes["snapshot.json"])
+		}
+	}
+}
+
+func TestDecodeSnapshot(t *testing.T) {
+	snapshot := loadFixture(t)
+	var buf bytes.Buffer
+	if err := snapshot.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dumpSnapshot(t, decoded), dumpSnapshot(t, snapshot)) {
+		t.Error("decoded snapshot differs")
+	}
+
+	if _, err := DecodeSnapshot(bytes.NewReader([]byte{0x1f, 0x8b, 0, 0})); err == nil {
+		t.Error("expect error of broken gzip")
+	}
+	path := filepath.Join(t.TempDir(), "broken.snapshot.json")
+	if err := os.WriteFile(path, []byte(`{"PidProcess": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSnapshot(path); err == nil {
+		t.Error("expect error of truncated json")
+	}
+}
+
+func TestDecodeProcessUID(t *testing.T) {
+	// a snapshot before uid, the owner is unknown rather than root
+	snapshot, err := DecodeSnapshot(bytes.NewReader([]byte(`{"PidProcess": {
+  "1": {"pid": 1, "name": "old", "user": "me"},
+  "2": {"pid": 2, "name": "sshd", "user": "root", "uid": 0}
+}}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := snapshot.PidProcess[1].UID; uid != -1 {
+		t.Errorf("missing uid decodes to %d, want -1", uid)
+	}
+	if uid := snapshot.PidProcess[2].UID; uid != 0 {
+		t.Errorf("uid 0 decodes to %d", uid)
+	}
+}
+
+func BenchmarkLoadSnapshot(b *testing.B) {
+	dir := b.TempDir()
+	snapshot := largeSnapshot(b, 5000, 20000)
+	for _, name := range []string{"snapshot.json", "snapshot.json.gz", "snapshot.json.zst"} {
+		path := filepath.Join(dir, name)
+		if err := snapshot.DumpFile(path); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := LoadSnapshot(path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sort"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/sirupsen/logrus"
+)
+
+// TakeTargetedSnapshot captures only what the topo of the config needs:
+// the connections are enumerated once, but the details (exe, user, ...) are only
+// fetched for the matched processes, their ancestors and children, and the peers.
+// It falls back to the full snapshot if the config matches all processes.
+func TakeTargetedSnapshot(ctx context.Context, kind string, cfg *Config, workers int) (*Snapshot, error) {
+	if cfg == nil || cfg.All || matchAll(cfg) {
+		return TakeSnapshotWithContext(ctx, kind, workers)
+	}
+
+	pids, err := process.PidsWithContext(ctx)
+	if err != nil {
+		logrus.WithError(err).Warning("get pid error")
+		return nil, err
+	}
+	procfs := NewProcFS(defaultProcRoot)
+	snapshot := NewSnapshot()
+	snapshot.Targeted = true
+	scanned, err := scanProcesses(ctx, procfs, pids, workers)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range scanned {
+		snapshot.PidProcess[p.Pid] = p
+		snapshot.PidListenPort[p.Pid] = NewPortSet()
+		snapshot.PidPort[p.Pid] = NewPortSet()
+	}
+	if err := snapshot.collectConnections(ctx, procfs, kind); err != nil {
+		return nil, err
+	}
+
+	targets := targetPids(snapshot, cfg)
+	processes, errs, err := inspectProcesses(ctx, procfs, targets, workers)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.keepProcesses(processes)
+	snapshot.InspectErrors = errs
+	logrus.WithFields(logrus.Fields{
+		"process": len(pids),
+		"target":  len(snapshot.PidProcess),
+	}).Debugln("take targeted snapshot")
+	return snapshot, nil
+}
+
+// matchAll tells whether the config matches all processes by `*`
+func matchAll(cfg *Config) bool {
+	for _, name := range cfg.Cmd {
+		if name == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// scanProcesses reads the little info to filter by from procfs only,
+// i.e. the parent, cmdline, container, unit and namespaces, by a pool of workers.
+// The errors are ignored, since the processes not matched do not matter and the matched ones are inspected again.
+func scanProcesses(ctx context.Context, procfs *ProcFS, pids []int32, workers int) ([]*Process, error) {
+	list, _, err := inspectPool(ctx, pids, workers, func(ctx context.Context, pid int32) (*Process, []*ProcessError) {
+		parent, err := procfs.Ppid(pid)
+		if errors.Is(err, fs.ErrNotExist) {
+			// exited
+			return nil, nil
+		}
+		p := &Process{Pid: pid, Parent: parent, UID: -1, Children: []int32{}}
+		p.Cmdline, _ = procfs.Cmdline(pid)
+		procfs.Inspect(p)
+		return p, nil
+	})
+	return list, err
+}
+
+// targetPids returns the processes in the topo of the config, in order of pid
+func targetPids(snapshot *Snapshot, cfg *Config) []int32 {
+	tp := NewTopo(snapshot)
+	tp.filter(cfg)
+
+	pids := make([]int32, 0, len(tp.PidSet))
+	for pid := range tp.PidSet {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids
+}
+
+// keepProcesses replaces the scanned processes with the inspected ones and drops the others,
+// the sockets are kept to find the peers. The children are kept from the scan, which sees all.
+func (s *Snapshot) keepProcesses(processes []*Process) {
+	kept := map[int32]*Process{}
+	for _, p := range processes {
+		if scanned, ok := s.PidProcess[p.Pid]; ok {
+			p.Children = scanned.Children
+		}
+		kept[p.Pid] = p
+	}
+	s.PidProcess = kept
+	for pid := range s.PidPort {
+		if _, ok := kept[pid]; !ok {
+			delete(s.PidPort, pid)
+		}
+	}
+	for pid := range s.PidListenPort {
+		if _, ok := kept[pid]; !ok {
+			delete(s.PidListenPort, pid)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func TestProcFSScan(t *testing.T) {
+	root := t.TempDir()
+	writeProcFixture(t, root, "1", "0::/init.scope\n", "4026531993", "4026531836")
+	writeProcFixture(t, root, "100", "0::/system.slice/app.service\n", "4026531993", "4026531836")
+	os.WriteFile(filepath.Join(root, "1", "stat"), []byte("1 (init) S 0 1 1 0 -1\n"), 0644)
+	os.WriteFile(filepath.Join(root, "1", "cmdline"), []byte("/sbin/init\x00"), 0644)
+	os.WriteFile(filepath.Join(root, "100", "stat"), []byte("100 (my app) (x) S 1 100 100 0 -1\n"), 0644)
+	os.WriteFile(filepath.Join(root, "100", "cmdline"), []byte("app\x00--port\x008080\x00"), 0644)
+
+	processes, err := scanProcesses(context.Background(), NewProcFS(root), []int32{100, 1, 200}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processes) != 2 {
+		t.Fatalf("expect 2 processes, got %d", len(processes))
+	}
+	init, app := processes[0], processes[1]
+	if app.Parent != 1 || app.Cmdline != "app --port 8080" || app.Unit != "app.service" {
+		t.Errorf("bad app %+v", app)
+	}
+	if !reflect.DeepEqual(init.Children, []int32{100}) || init.Cmdline != "/sbin/init" {
+		t.Errorf("bad init %+v", init)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := scanProcesses(ctx, NewProcFS(root), []int32{100, 1, 200}, 2); !errors.Is(err, context.Canceled) {
+		t.Errorf("expect canceled, got %v", err)
+	}
+}
+
+// targetSnapshot is the app and postgres in baselineSnapshot, with init, a worker child
+// of the app, and a noisy process the filter does not care about.
+func targetSnapshot() *Snapshot {
+	snapshot := baselineSnapshot(100, 40000)
+	snapshot.PidProcess[1] = &Process{Pid: 1, Name: "init", Cmdline: "/sbin/init", Children: []int32{100, 101, 300}}
+	snapshot.PidProcess[100].Parent = 1
+	snapshot.PidProcess[100].Children = []int32{102}
+	snapshot.PidProcess[101].Parent = 1
+	snapshot.PidProcess[102] = &Process{Pid: 102, Name: "worker", Cmdline: "worker", Parent: 100}
+	snapshot.PidProcess[300] = &Process{Pid: 300, Name: "redis", Cmdline: "redis-server", Parent: 1}
+	for _, pid := range []int32{1, 102, 300} {
+		snapshot.PidPort[pid] = NewPortSet()
+		snapshot.PidListenPort[pid] = NewPortSet()
+	}
+	snapshot.addConnection(&snapshot.PortIndex, net.ConnectionStat{Pid: 300, Status: "LISTEN",
+		Laddr: net.Addr{IP: "127.0.0.1", Port: 6379}})
+	return snapshot
+}
+
+func TestTargetPids(t *testing.T) {
+	cases := []struct {
+		cfg  *Config
+		want []int32
+	}{
+		{&Config{Cmd: []string{"app"}}, []int32{1, 100, 101, 102}},
+		{&Config{Pid: []int32{300}}, []int32{1, 300}},
+		{&Config{Port: []uint32{5432}}, []int32{1, 101}},
+	}
+	for _, c := range cases {
+		if got := targetPids(targetSnapshot(), c.cfg); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("targets of %+v: expect %v, got %v", c.cfg, c.want, got)
+		}
+	}
+}
+
+func TestTargetedTopo(t *testing.T) {
+	cfg := &Config{Cmd: []string{"app"}}
+	full := NewTopo(targetSnapshot()).Analyse(cfg)
+
+	snapshot := targetSnapshot()
+	var inspected []*Process
+	for _, pid := range targetPids(snapshot, cfg) {
+		p := *snapshot.PidProcess[pid]
+		p.Children = nil
+		inspected = append(inspected, &p)
+	}
+	snapshot.keepProcesses(inspected)
+	if _, ok := snapshot.PidProcess[300]; ok {
+		t.Fatal("expect pid 300 dropped")
+	}
+	if _, ok := snapshot.PidPort[300]; ok {
+		t.Fatal("expect ports of pid 300 dropped")
+	}
+	if !reflect.DeepEqual(snapshot.PidProcess[100].Children, []int32{102}) {
+		t.Errorf("expect children kept from scan, got %v", snapshot.PidProcess[100].Children)
+	}
+
+	targeted := NewTopo(snapshot).Analyse(cfg)
+	if !reflect.DeepEqual(pidKeys(full.PidSet), pidKeys(targeted.PidSet)) {
+		t.Errorf("expect same processes, %v != %v", pidKeys(full.PidSet), pidKeys(targeted.PidSet))
+	}
+	if len(full.PidConnSet) != len(targeted.PidConnSet) || len(full.IPConnSet) != len(targeted.IPConnSet) ||
+		len(full.PidChildSet) != len(targeted.PidChildSet) {
+		t.Errorf("expect same edges, %d/%d/%d != %d/%d/%d",
+			len(full.PidConnSet), len(full.IPConnSet), len(full.PidChildSet),
+			len(targeted.PidConnSet), len(targeted.IPConnSet), len(targeted.PidChildSet))
+	}
+}
+
+func pidKeys(set map[int32]*Process) map[int32]bool {
+	keys := map[int32]bool{}
+	for pid := range set {
+		keys[pid] = true
+	}
+	return keys
+}
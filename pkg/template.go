@@ -52,6 +52,10 @@ const tmplGraph = `digraph pstopo {
 	
 	{{template "legend" .}}
 
+	{{range .Clusters}}
+	{{template "cluster" .}}
+	{{- end}}
+
 	{{range .Nodes}}
 	{{template "node" .}}
 	{{- end}}
@@ -1,8 +1,8 @@
 package pkg
 
 import (
-	"fmt"
 	gonet "net"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -15,9 +15,11 @@ type PSTopo struct {
 	graph.Graph
 	Snapshot    *Snapshot
 	PidSet      map[int32]*Process
-	PidConnSet  map[string]*TopoEdge
-	IPConnSet   map[string]*TopoEdge
-	PidChildSet map[string]*TopoEdge
+	PidConnSet  map[ConnKey]*TopoEdge
+	IPConnSet   map[ConnKey]*TopoEdge
+	PidChildSet map[EdgeKey]*TopoEdge
+	IPGroupSet  map[string]*IPGroup
+	ClusterSet  map[string]*ProcessCluster
 }
 
 type TopoEdge struct {
@@ -26,13 +28,34 @@ type TopoEdge struct {
 	Connection net.ConnectionStat
 }
 
+// ConnKey identifies a connection of a process, to dedup the edges
+type ConnKey struct {
+	Family uint32
+	Type   uint32
+	Laddr  net.Addr
+	Raddr  net.Addr
+	Pid    int32
+}
+
+func NewConnKey(conn net.ConnectionStat) ConnKey {
+	return ConnKey{Family: conn.Family, Type: conn.Type, Laddr: conn.Laddr, Raddr: conn.Raddr, Pid: conn.Pid}
+}
+
+// EdgeKey identifies an edge between processes
+type EdgeKey struct {
+	From int32
+	To   int32
+}
+
 func NewTopo(snapshot *Snapshot) *PSTopo {
 	return &PSTopo{
 		Snapshot:    snapshot,
 		PidSet:      map[int32]*Process{},
-		PidConnSet:  map[string]*TopoEdge{},
-		IPConnSet:   map[string]*TopoEdge{},
-		PidChildSet: map[string]*TopoEdge{},
+		PidConnSet:  map[ConnKey]*TopoEdge{},
+		IPConnSet:   map[ConnKey]*TopoEdge{},
+		PidChildSet: map[EdgeKey]*TopoEdge{},
+		IPGroupSet:  map[string]*IPGroup{},
+		ClusterSet:  map[string]*ProcessCluster{},
 	}
 }
 
@@ -40,6 +63,92 @@ func (t *TopoEdge) String() string {
 	return strconv.Itoa(int(t.From)) + "->" + strconv.Itoa(int(t.To))
 }
 
+// Processes returns the processes of the topo, in order of pid
+func (tp *PSTopo) Processes() []*Process {
+	list := make([]*Process, 0, len(tp.PidSet))
+	for _, p := range tp.PidSet {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Pid < list[j].Pid })
+	return list
+}
+
+// ChildEdges returns the edges of parent to child, in order of pid
+func (tp *PSTopo) ChildEdges() []*TopoEdge {
+	return sortedEdges(tp.PidChildSet)
+}
+
+// ConnEdges returns the edges between processes, in order of pid and then the connection
+func (tp *PSTopo) ConnEdges() []*TopoEdge {
+	return sortedEdges(tp.PidConnSet)
+}
+
+// IPEdges returns the edges of a process to an external ip, in order of pid and then the connection
+func (tp *PSTopo) IPEdges() []*TopoEdge {
+	return sortedEdges(tp.IPConnSet)
+}
+
+// Clusters returns the process clusters, in order of id
+func (tp *PSTopo) Clusters() []*ProcessCluster {
+	list := make([]*ProcessCluster, 0, len(tp.ClusterSet))
+	for _, c := range tp.ClusterSet {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// IPGroups returns the groups of external ip, in order of pid and then the name
+func (tp *PSTopo) IPGroups() []*IPGroup {
+	list := make([]*IPGroup, 0, len(tp.IPGroupSet))
+	for _, g := range tp.IPGroupSet {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].From != list[j].From {
+			return list[i].From < list[j].From
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+func sortedEdges[K comparable](set map[K]*TopoEdge) []*TopoEdge {
+	list := make([]*TopoEdge, 0, len(set))
+	for _, e := range set {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return edgeLess(list[i], list[j]) })
+	return list
+}
+
+func edgeLess(a, b *TopoEdge) bool {
+	if a.From != b.From {
+		return a.From < b.From
+	}
+	if a.To != b.To {
+		return a.To < b.To
+	}
+	return connLess(a.Connection, b.Connection)
+}
+
+// connLess orders connections by the local and then the remote address
+func connLess(a, b net.ConnectionStat) bool {
+	if a.Laddr.Port != b.Laddr.Port {
+		return a.Laddr.Port < b.Laddr.Port
+	}
+	if a.Laddr.IP != b.Laddr.IP {
+		return a.Laddr.IP < b.Laddr.IP
+	}
+	if a.Raddr.IP != b.Raddr.IP {
+		return a.Raddr.IP < b.Raddr.IP
+	}
+	if a.Raddr.Port != b.Raddr.Port {
+		return a.Raddr.Port < b.Raddr.Port
+	}
+	return a.Pid < b.Pid
+}
+
 func (tp *PSTopo) linkProcess(pid, pid2 int32) {
 	if pid == 0 || pid2 == 0 {
 		return
@@ -48,7 +157,7 @@ func (tp *PSTopo) linkProcess(pid, pid2 int32) {
 		return
 	}
 
-	key := fmt.Sprintf("%d->%d", pid, pid2)
+	key := EdgeKey{From: pid, To: pid2}
 	if _, ok := tp.PidChildSet[key]; ok {
 		return
 	}
@@ -65,11 +174,11 @@ func (tp *PSTopo) linkPidPort(pid int32, pid2 int32, conn net.ConnectionStat) {
 	if pid == pid2 {
 		return
 	}
-	_, ok := tp.PidConnSet[conn.String()]
-	if ok {
+	key := NewConnKey(conn)
+	if _, ok := tp.PidConnSet[key]; ok {
 		return
 	}
-	tp.PidConnSet[conn.String()] = &TopoEdge{
+	tp.PidConnSet[key] = &TopoEdge{
 		From:       pid,
 		To:         pid2,
 		Connection: conn,
@@ -98,10 +207,11 @@ func (tp *PSTopo) linkIPPort(pid int32, conn net.ConnectionStat) {
 	if pid == 0 {
 		return
 	}
-	if _, ok := tp.IPConnSet[conn.String()]; ok {
+	key := NewConnKey(conn)
+	if _, ok := tp.IPConnSet[key]; ok {
 		return
 	}
-	tp.IPConnSet[conn.String()] = &TopoEdge{
+	tp.IPConnSet[key] = &TopoEdge{
 		From:       pid,
 		Connection: conn,
 	}
@@ -109,7 +219,10 @@ func (tp *PSTopo) linkIPPort(pid int32, conn net.ConnectionStat) {
 
 func (tp *PSTopo) addPidParent(pid int32) int32 {
 	snapshot := tp.Snapshot
-	process := snapshot.PidProcess[pid]
+	process, ok := snapshot.PidProcess[pid]
+	if !ok {
+		return 0
+	}
 	if parentProcess, ok := snapshot.PidProcess[process.Parent]; ok {
 		tp.linkProcess(process.Parent, pid)
 		tp.addProcess(parentProcess)
@@ -120,7 +233,10 @@ func (tp *PSTopo) addPidParent(pid int32) int32 {
 
 func (tp *PSTopo) addPidChildren(pid int32) {
 	snapshot := tp.Snapshot
-	process := snapshot.PidProcess[pid]
+	process, ok := snapshot.PidProcess[pid]
+	if !ok {
+		return
+	}
 	for _, child := range process.Children {
 		if childProcess, ok := snapshot.PidProcess[child]; ok {
 			tp.linkProcess(pid, child)
@@ -143,33 +259,29 @@ func (tp *PSTopo) addPidNeighbor(pid int32) {
 	}
 }
 
-func (tp *PSTopo) processPort(ports map[uint32]bool) {
-	snapshot := tp.Snapshot
-	var listenPorts []uint32
-	var establishPorts []uint32
-	for port := range ports {
-		_, ok := tp.Snapshot.ListenPortPid[port]
-		if ok {
-			listenPorts = append(listenPorts, port)
-		} else {
-			establishPorts = append(establishPorts, port)
-		}
-	}
+// nsPort is a port in a network namespace
+type nsPort struct {
+	NetNS uint64
+	Port  uint32
+}
 
-	for _, port := range listenPorts {
-		// listen Port
-		listenPort := port
-		listenPid, _ := snapshot.ListenPortPid[listenPort]
+// processListenPorts links the connected processes to the listening ones in the topo
+func (tp *PSTopo) processListenPorts(ports []nsPort) {
+	snapshot := tp.Snapshot
+	for _, port := range ports {
+		idx := snapshot.Index(port.NetNS)
+		listenPort := port.Port
+		listenPid, _ := idx.ListenPortPid[listenPort]
 
 		// filter
 		if _, ok := tp.PidSet[listenPid]; !ok {
 			continue
 		}
 
-		connections := snapshot.ListenPortConnections[listenPort]
+		connections := idx.ListenPortConnections[listenPort]
 		for _, conn := range connections {
 			connPort := conn.Laddr.Port
-			connPid, ok := snapshot.PortPid[connPort]
+			connPid, ok := idx.PortPid[connPort]
 			if ok {
 				tp.addPid(listenPid)
 				tp.addPid(connPid)
@@ -180,22 +292,27 @@ func (tp *PSTopo) processPort(ports map[uint32]bool) {
 
 		}
 	}
+}
 
-	for _, localPort := range establishPorts {
-		// establish Port
-		connPid, ok := snapshot.PortPid[localPort]
+// processEstablishPorts links the processes in the topo to the peer processes or the external ip
+func (tp *PSTopo) processEstablishPorts(ports []nsPort) {
+	snapshot := tp.Snapshot
+	for _, port := range ports {
+		idx := snapshot.Index(port.NetNS)
+		localPort := port.Port
+		connPid, ok := idx.PortPid[localPort]
 		if ok {
 			// filter
 			if _, ok := tp.PidSet[connPid]; !ok {
 				continue
 			}
 
-			conn := snapshot.GetConnection(localPort)
+			conn := idx.GetConnection(localPort)
 			if conn.Laddr.Port == localPort { // redundant
-				remoteIP, remotePort := conn.Raddr.IP, conn.Raddr.Port
+				remoteIP := conn.Raddr.IP
 				if isPrivateIP(gonet.ParseIP(remoteIP)) {
-					// remote is process
-					remotePid, ok := snapshot.PortPid[remotePort]
+					// remote is process, maybe in other network namespace
+					remotePid, ok := snapshot.FindPeer(connPid, conn)
 					if ok {
 						tp.addPid(connPid)
 						tp.addPid(remotePid)
@@ -220,9 +337,10 @@ func (tp *PSTopo) Analyse(cfg *Config) *PSTopo {
 			tp.addPidNeighbor(pid)
 		}
 		for pid, ports := range snapshot.PidPort {
-			for port := range ports.Iter() {
-				conn := snapshot.GetConnection(port)
-				otherPid := snapshot.PortPid[conn.Raddr.Port]
+			idx := snapshot.PidIndex(pid)
+			for _, port := range ports.Ports() {
+				conn := idx.GetConnection(port)
+				otherPid, _ := snapshot.FindPeer(pid, conn)
 				tp.linkPidPort(pid, otherPid, conn)
 			}
 		}
@@ -230,6 +348,14 @@ func (tp *PSTopo) Analyse(cfg *Config) *PSTopo {
 		tp.filter(cfg)
 	}
 
+	tp.clusterProcess(cfg)
+
+	if cfg.Group {
+		if err := tp.groupIP(cfg); err != nil {
+			logrus.WithError(err).Warningln("group external ip error")
+		}
+	}
+
 	return tp
 }
 
@@ -244,30 +370,50 @@ func (tp *PSTopo) filterPid(cfg *Config) map[int32]bool {
 	}
 
 	// check all
+	names := cfg.Cmd
 	useAll := false
-	for _, name := range cfg.Cmd {
+	for _, name := range names {
 		if name == "*" {
 			useAll = true
 			break
 		}
 	}
+	var containers []string
+	for _, id := range cfg.Container {
+		if id != "" {
+			containers = append(containers, id)
+		}
+	}
+	var units []string
+	for _, unit := range cfg.Unit {
+		if unit != "" {
+			units = append(units, unit)
+		}
+	}
 
-	// filter by name
-	for _, name := range cfg.Cmd {
-		for _, p := range snapshot.Processes() {
-			if useAll || strings.Contains(p.Cmdline, name) {
+	// filter by name, container and systemd unit, in one pass of the processes
+	if len(names) > 0 || len(containers) > 0 || len(units) > 0 {
+		for _, p := range snapshot.PidProcess {
+			if len(names) > 0 && (useAll || containsAny(p.Cmdline, names)) {
 				pids[p.Pid] = true
 				for _, c := range p.Children {
 					pids[c] = true
 				}
 			}
+			if p.ContainerID != "" && hasAnyPrefix(p.ContainerID, containers) {
+				pids[p.Pid] = true
+			}
+			// the suffix of unit can be omitted
+			if p.Unit != "" && matchUnit(p.Unit, units) {
+				pids[p.Pid] = true
+			}
 		}
 	}
 
-	// filter by (listen) port
+	// filter by (listen) port, in any network namespace
 	for _, port := range cfg.Port {
-		for listenPort, pid := range snapshot.ListenPortPid {
-			if port == listenPort {
+		for _, idx := range snapshot.Indexes() {
+			if pid, ok := idx.ListenPortPid[port]; ok {
 				pids[pid] = true
 			}
 		}
@@ -276,33 +422,76 @@ func (tp *PSTopo) filterPid(cfg *Config) map[int32]bool {
 	return pids
 }
 
-func (tp *PSTopo) filterPort(cfg *Config) map[uint32]bool {
-	snapshot := tp.Snapshot
-	ports := map[uint32]bool{}
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
 
-	// filter by (listen) port
-	for _, port := range cfg.Port {
-		ports[port] = true
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
 	}
+	return false
+}
 
-	// add all ports for the pid
-	for _, set := range snapshot.PidPort {
-		if set != nil {
-			for port := range set.Iter() {
-				ports[port] = true
-			}
+func matchUnit(unit string, units []string) bool {
+	for _, u := range units {
+		if unit == u || strings.HasPrefix(unit, u+".") {
+			return true
 		}
 	}
-	for _, set := range snapshot.PidListenPort {
-		if set != nil {
-			for port := range set.Iter() {
-				ports[port] = true
+	return false
+}
+
+// filterPort returns the sorted listen (or established) ports of the processes in the topo,
+// in their network namespaces. The listen ports of the config are in any network namespace.
+func (tp *PSTopo) filterPort(cfg *Config, listen bool) []nsPort {
+	snapshot := tp.Snapshot
+	ports := map[nsPort]bool{}
+
+	if listen {
+		for _, port := range cfg.Port {
+			ports[nsPort{Port: port}] = true
+			for ns := range snapshot.Namespaces {
+				ports[nsPort{NetNS: ns, Port: port}] = true
 			}
 		}
 	}
 
-	return ports
+	// an accepted socket has the local port of listening, it is processed as the listen one
+	add := func(ns uint64, idx *PortIndex, set *PortSet) {
+		for _, port := range set.Ports() {
+			if _, ok := idx.ListenPortPid[port]; ok == listen {
+				ports[nsPort{NetNS: ns, Port: port}] = true
+			}
+		}
+	}
+	for pid := range tp.PidSet {
+		ns := snapshot.pidNetNS(pid)
+		idx := snapshot.Index(ns)
+		add(ns, idx, snapshot.PidPort[pid])
+		add(ns, idx, snapshot.PidListenPort[pid])
+	}
 
+	list := make([]nsPort, 0, len(ports))
+	for port := range ports {
+		if _, ok := snapshot.Index(port.NetNS).ListenPortPid[port.Port]; ok == listen {
+			list = append(list, port)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].NetNS != list[j].NetNS {
+			return list[i].NetNS < list[j].NetNS
+		}
+		return list[i].Port < list[j].Port
+	})
+	return list
 }
 
 func (tp *PSTopo) filter(cfg *Config) {
@@ -315,7 +504,7 @@ func (tp *PSTopo) filter(cfg *Config) {
 		tp.addPidNeighbor(pid)
 	}
 
-	// process port
-	ports := tp.filterPort(cfg)
-	tp.processPort(ports)
+	// the listen ports link the connected processes, whose established ports are processed then
+	tp.processListenPorts(tp.filterPort(cfg, true))
+	tp.processEstablishPorts(tp.filterPort(cfg, false))
 }
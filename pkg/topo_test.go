@@ -1,30 +1,101 @@
 package pkg
 
 import (
-	"os"
+	"fmt"
 	"testing"
-
-	jsoniter "github.com/json-iterator/go"
 )
 
-func generateSnapshot() *Snapshot {
-	cachedSnapshot := "./latest.json"
-	snapshot := NewSnapshot()
-	var json = jsoniter.ConfigCompatibleWithStandardLibrary
-	data, _ := os.ReadFile(cachedSnapshot)
-	err := json.Unmarshal(data, snapshot)
+func TestAnalyseSnapshot(t *testing.T) {
+	snapshot, err := GenerateSnapshot(DefaultFakeSpec())
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		Cmd: []string{"jobs"},
+	}
+	topo := NewTopo(snapshot).Analyse(cfg)
+
+	// the jobs and the workers, init, postgres and redis
+	names := map[string]int{}
+	for _, p := range topo.Processes() {
+		names[p.Name]++
+	}
+	if names["jobs"] != 3 || names["systemd"] != 1 || names["postgres"] == 0 || names["redis"] == 0 || names["api"] != 0 {
+		t.Errorf("bad processes of topo %v", names)
+	}
+	if len(topo.IPEdges()) != 1 {
+		t.Errorf("expect 1 ip edge, got %d", len(topo.IPEdges()))
+	}
+}
+
+// largeSnapshot generates about the processes and sockets by GenerateSnapshot.
+// The services `svc-N` of 10 processes are spread over network namespaces of 1000 processes,
+// since the ephemeral ports are of each namespace. Every service calls the next one
+// in its namespace by loopback (2 sockets), and an external endpoint (1 socket).
+func largeSnapshot(tb testing.TB, processes int, connections int) *Snapshot {
+	tb.Helper()
+	const perNS = 100
+	services := max(processes/10, 1)
+	spec := &FakeSpec{Host: "large", Seed: 1, PidMax: 4194304}
+	for i := 0; i < services; i++ {
+		ns, n := i/perNS, i%perNS
+		next := ns*perNS + (n+1)%perNS
+		if next >= services {
+			next = ns * perNS
+		}
+		svc := &FakeService{
+			Name:        fmt.Sprintf("svc-%d", i),
+			User:        "app",
+			Workers:     9,
+			Listen:      []uint32{uint32(5000 + n)},
+			Bind:        "127.0.0.1",
+			Calls:       []string{fmt.Sprintf("svc-%d", next), fmt.Sprintf("ext-%d.example.com:443", i%256)},
+			Connections: max(connections/(3*services), 1),
+		}
+		if ns > 0 {
+			svc.Namespace = fmt.Sprintf("ns-%d", ns)
+		}
+		spec.Services = append(spec.Services, svc)
+	}
+	snapshot, err := GenerateSnapshot(spec)
+	if err != nil {
+		tb.Fatal(err)
 	}
 	return snapshot
 }
 
-func TestAnalyseSnapshot(t *testing.T) {
-	snapshot := generateSnapshot()
-	cfg := &Config{
-		Cmd: []string{"Elec"},
+func BenchmarkAnalyse(b *testing.B) {
+	sizes := []struct{ processes, connections int }{
+		{5000, 20000},
+		{50000, 200000},
+	}
+	configs := map[string]*Config{
+		"cmd":  {Cmd: []string{"svc-10", "svc-42"}},
+		"port": {Port: []uint32{5001}},
+		"all":  {All: true},
+	}
+	for _, size := range sizes {
+		snapshot := largeSnapshot(b, size.processes, size.connections)
+		for _, name := range []string{"cmd", "port", "all"} {
+			b.Run(fmt.Sprintf("%s/processes=%d/connections=%d", name, size.processes, size.connections), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					NewTopo(snapshot).Analyse(configs[name])
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkPortSet(b *testing.B) {
+	set := NewPortSet()
+	for port := uint32(0); port < 64; port++ {
+		set.Add(port * 7 % 64)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n := 0
+		for _, port := range set.Ports() {
+			n += int(port)
+		}
 	}
-	topo := NewTopo(snapshot)
-	topo = topo.Analyse(cfg)
-	println(topo)
 }